@@ -0,0 +1,347 @@
+package smtpclient
+
+import (
+	"encoding/base64"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"mime/quotedprintable"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/emersion/go-imap"
+	"github.com/emersion/go-imap/client"
+)
+
+// IMAPPartNode mirrors one node of a message's BODYSTRUCTURE, addressed by
+// its IMAP section path (e.g. [1, 2] for part "1.2"). Building this tree up
+// front lets callers fetch exactly the part they need instead of decoding
+// the whole message.
+type IMAPPartNode struct {
+	Path        []int
+	MIMEType    string
+	MIMESubType string
+	Filename    string
+	ContentID   string
+	Encoding    string
+	Size        uint32
+	Children    []*IMAPPartNode
+}
+
+// MIME returns the part's full MIME type, e.g. "text/html".
+func (n *IMAPPartNode) MIME() string {
+	return n.MIMEType + "/" + n.MIMESubType
+}
+
+// sectionPath formats Path the way IMAP section specifiers expect: "1.2.1".
+func (n *IMAPPartNode) sectionPath() string {
+	return pathString(n.Path)
+}
+
+func pathString(path []int) string {
+	parts := make([]string, len(path))
+	for i, p := range path {
+		parts[i] = strconv.Itoa(p)
+	}
+	return strings.Join(parts, ".")
+}
+
+// find returns the node at path, or nil if there's no such part.
+func (n *IMAPPartNode) find(path []int) *IMAPPartNode {
+	if len(path) == 0 {
+		return n
+	}
+	if equalPaths(n.Path, path) {
+		return n
+	}
+	for _, child := range n.Children {
+		if found := child.find(path); found != nil {
+			return found
+		}
+	}
+	return nil
+}
+
+// findByContentID walks the tree looking for the part with the given
+// Content-ID (without angle brackets).
+func (n *IMAPPartNode) findByContentID(cid string) *IMAPPartNode {
+	if n.ContentID == cid {
+		return n
+	}
+	for _, child := range n.Children {
+		if found := child.findByContentID(cid); found != nil {
+			return found
+		}
+	}
+	return nil
+}
+
+// TextPart returns the best part to render as the message body, preferring
+// text/html and falling back to text/plain.
+func (n *IMAPPartNode) TextPart() *IMAPPartNode {
+	var plain *IMAPPartNode
+
+	var walk func(*IMAPPartNode) *IMAPPartNode
+	walk = func(node *IMAPPartNode) *IMAPPartNode {
+		if node.MIMEType == "text" {
+			switch node.MIMESubType {
+			case "html":
+				return node
+			case "plain":
+				if plain == nil {
+					plain = node
+				}
+			}
+		}
+		for _, child := range node.Children {
+			if found := walk(child); found != nil {
+				return found
+			}
+		}
+		return nil
+	}
+
+	if found := walk(n); found != nil {
+		return found
+	}
+	return plain
+}
+
+// Attachments returns every part in the tree that carries a filename, i.e.
+// every part meant to be downloaded rather than rendered inline.
+func (n *IMAPPartNode) Attachments() []*IMAPPartNode {
+	var result []*IMAPPartNode
+
+	var walk func(*IMAPPartNode)
+	walk = func(node *IMAPPartNode) {
+		if node.Filename != "" {
+			result = append(result, node)
+		}
+		for _, child := range node.Children {
+			walk(child)
+		}
+	}
+	walk(n)
+
+	return result
+}
+
+func equalPaths(a, b []int) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// buildPartTree converts an IMAP BODYSTRUCTURE response into an IMAPPartNode
+// tree, mirroring the approach alps uses for its PartTree.
+func buildPartTree(bs *imap.BodyStructure) *IMAPPartNode {
+	return buildPartNode(bs, nil)
+}
+
+func buildPartNode(bs *imap.BodyStructure, path []int) *IMAPPartNode {
+	// A non-multipart message has no BODYSTRUCTURE parts of its own; IMAP
+	// still addresses its one and only part as section "1", not "" (an
+	// empty section path means the whole raw message, headers included).
+	if len(path) == 0 && len(bs.Parts) == 0 {
+		path = []int{1}
+	}
+
+	node := &IMAPPartNode{
+		Path:        append([]int{}, path...),
+		MIMEType:    strings.ToLower(bs.MIMEType),
+		MIMESubType: strings.ToLower(bs.MIMESubType),
+		Encoding:    bs.Encoding,
+		Size:        bs.Size,
+		ContentID:   strings.Trim(bs.Id, "<>"),
+	}
+
+	if name, ok := bs.Params["name"]; ok {
+		node.Filename = name
+	}
+	if filename, ok := bs.DispositionParams["filename"]; ok {
+		node.Filename = filename
+	}
+
+	if len(bs.Parts) == 0 {
+		return node
+	}
+
+	for i, part := range bs.Parts {
+		childPath := append(append([]int{}, path...), i+1)
+		node.Children = append(node.Children, buildPartNode(part, childPath))
+	}
+
+	return node
+}
+
+// fetchPartTree fetches BODYSTRUCTURE for uid and builds its IMAPPartNode
+// tree. The caller must have already SELECTed the right folder on
+// imapClient.
+func fetchPartTree(imapClient *client.Client, uid uint32) (*IMAPPartNode, error) {
+	seqSet := uidSeqSet([]uint32{uid})
+	items := []imap.FetchItem{imap.FetchBodyStructure}
+	messages := make(chan *imap.Message, 1)
+	done := make(chan error, 1)
+
+	go func() {
+		done <- imapClient.UidFetch(seqSet, items, messages)
+	}()
+
+	var tree *IMAPPartNode
+	for msg := range messages {
+		if msg.BodyStructure != nil {
+			tree = buildPartTree(msg.BodyStructure)
+		}
+	}
+
+	if err := <-done; err != nil {
+		return nil, fmt.Errorf("failed to fetch body structure: %w", err)
+	}
+	if tree == nil {
+		return nil, fmt.Errorf("message %d has no body structure", uid)
+	}
+
+	return tree, nil
+}
+
+// GetMessagePart streams a single MIME part of uid in folder, identified by
+// its section path, without loading the rest of the message into memory. It
+// fetches BODY.PEEK so the \Seen flag isn't set as a side effect of reading
+// an attachment.
+func (c *IMAPClient) GetMessagePart(folder string, uid uint32, path []int) (reader io.ReadCloser, mimeType string, filename string, size uint32, err error) {
+	err = c.withFolder(folder, func(imapClient *client.Client, _ *imap.MailboxStatus) error {
+		tree, err := fetchPartTree(imapClient, uid)
+		if err != nil {
+			return err
+		}
+
+		node := tree.find(path)
+		if node == nil {
+			return fmt.Errorf("no such message part %s", pathString(path))
+		}
+
+		reader, mimeType, filename, size, err = fetchPart(imapClient, uid, node)
+		return err
+	})
+
+	return reader, mimeType, filename, size, err
+}
+
+// fetchPart fetches and decodes node's content for uid. The caller must
+// already have SELECTed the right folder on imapClient.
+func fetchPart(imapClient *client.Client, uid uint32, node *IMAPPartNode) (io.ReadCloser, string, string, uint32, error) {
+	section := &imap.BodySectionName{
+		BodyPartName: imap.BodyPartName{Path: node.Path},
+		Peek:         true,
+	}
+
+	seqSet := uidSeqSet([]uint32{uid})
+	items := []imap.FetchItem{section.FetchItem()}
+	messages := make(chan *imap.Message, 1)
+	done := make(chan error, 1)
+
+	go func() {
+		done <- imapClient.UidFetch(seqSet, items, messages)
+	}()
+
+	var body imap.Literal
+	for msg := range messages {
+		body = msg.GetBody(section)
+	}
+
+	if err := <-done; err != nil {
+		return nil, "", "", 0, fmt.Errorf("failed to fetch message part %s: %w", node.sectionPath(), err)
+	}
+	if body == nil {
+		return nil, "", "", 0, fmt.Errorf("message part %s not found", node.sectionPath())
+	}
+
+	decoded, err := decodePartBody(body, node.Encoding)
+	if err != nil {
+		return nil, "", "", 0, fmt.Errorf("failed to decode message part %s: %w", node.sectionPath(), err)
+	}
+
+	return ioutil.NopCloser(decoded), node.MIME(), node.Filename, node.Size, nil
+}
+
+// decodePartBody wraps r with a decoder for the part's IMAP content transfer
+// encoding; BODY.PEEK returns parts exactly as encoded on the wire.
+func decodePartBody(r io.Reader, encoding string) (io.Reader, error) {
+	switch strings.ToLower(encoding) {
+	case "quoted-printable":
+		return quotedprintable.NewReader(r), nil
+	case "base64":
+		return base64.NewDecoder(base64.StdEncoding, r), nil
+	default:
+		return r, nil
+	}
+}
+
+// populateBodyAndAttachments fills in message.Body and message.Attachments
+// from tree, fetching only the text part and the attachment parts rather
+// than the whole message. The caller must already have SELECTed the right
+// folder on imapClient.
+func populateBodyAndAttachments(imapClient *client.Client, uid uint32, folder string, tree *IMAPPartNode, message *Message) error {
+	if textNode := tree.TextPart(); textNode != nil {
+		reader, _, _, _, err := fetchPart(imapClient, uid, textNode)
+		if err != nil {
+			return fmt.Errorf("failed to fetch message body: %w", err)
+		}
+		body, err := ioutil.ReadAll(reader)
+		reader.Close()
+		if err != nil {
+			return fmt.Errorf("failed to read message body: %w", err)
+		}
+
+		bodyText := string(body)
+		if textNode.MIMESubType == "html" {
+			bodyText = ResolveInlineCIDs(bodyText, tree, func(path []int) string {
+				return fmt.Sprintf("/api/messages/%s/%d/parts/%s", folder, uid, pathString(path))
+			})
+		}
+		message.Body = bodyText
+	}
+
+	for _, attachmentNode := range tree.Attachments() {
+		reader, mimeType, filename, _, err := fetchPart(imapClient, uid, attachmentNode)
+		if err != nil {
+			return fmt.Errorf("failed to fetch attachment %s: %w", attachmentNode.Filename, err)
+		}
+		content, err := ioutil.ReadAll(reader)
+		reader.Close()
+		if err != nil {
+			return fmt.Errorf("failed to read attachment %s: %w", attachmentNode.Filename, err)
+		}
+
+		message.Attachments = append(message.Attachments, Attachment{
+			Filename: filename,
+			Content:  content,
+			MimeType: mimeType,
+		})
+	}
+
+	return nil
+}
+
+var cidReferenceRegexp = regexp.MustCompile(`cid:([^"'\)\s]+)`)
+
+// ResolveInlineCIDs rewrites cid: references in an HTML body into URLs built
+// by urlFor, so that inline images resolve to a part-fetch endpoint instead
+// of a dangling Content-ID reference.
+func ResolveInlineCIDs(html string, tree *IMAPPartNode, urlFor func(path []int) string) string {
+	return cidReferenceRegexp.ReplaceAllStringFunc(html, func(match string) string {
+		cid := strings.TrimPrefix(match, "cid:")
+		node := tree.findByContentID(cid)
+		if node == nil {
+			return match
+		}
+		return urlFor(node.Path)
+	})
+}
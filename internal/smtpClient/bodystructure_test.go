@@ -0,0 +1,52 @@
+package smtpclient
+
+import "testing"
+
+func TestResolveInlineCIDs(t *testing.T) {
+	tree := &IMAPPartNode{
+		Path: []int{1},
+		Children: []*IMAPPartNode{
+			{Path: []int{1, 1}, MIMEType: "text", MIMESubType: "html"},
+			{Path: []int{1, 2}, ContentID: "logo@example.com"},
+		},
+	}
+
+	urlFor := func(path []int) string {
+		return "/api/messages/INBOX/1/parts/" + pathString(path)
+	}
+
+	tests := []struct {
+		name string
+		html string
+		want string
+	}{
+		{
+			name: "known cid is rewritten to a part URL",
+			html: `<img src="cid:logo@example.com">`,
+			want: `<img src="/api/messages/INBOX/1/parts/1.2">`,
+		},
+		{
+			name: "cid wrapped in quotes and parens is matched without them",
+			html: `background: url(cid:logo@example.com)`,
+			want: `background: url(/api/messages/INBOX/1/parts/1.2)`,
+		},
+		{
+			name: "unknown cid is left untouched",
+			html: `<img src="cid:missing@example.com">`,
+			want: `<img src="cid:missing@example.com">`,
+		},
+		{
+			name: "html without cid references is unchanged",
+			html: `<p>no attachments here</p>`,
+			want: `<p>no attachments here</p>`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := ResolveInlineCIDs(tt.html, tree, urlFor); got != tt.want {
+				t.Errorf("ResolveInlineCIDs(%q) = %q, want %q", tt.html, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,160 @@
+package smtpclient
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/emersion/go-imap"
+	specialuse "github.com/emersion/go-imap-specialuse"
+	"github.com/emersion/go-imap/client"
+)
+
+// FolderType is the canonical role a folder plays, independent of whatever
+// name the server or user gave it.
+type FolderType string
+
+const (
+	FolderInbox   FolderType = "Inbox"
+	FolderSent    FolderType = "Sent"
+	FolderDrafts  FolderType = "Drafts"
+	FolderTrash   FolderType = "Trash"
+	FolderJunk    FolderType = "Junk"
+	FolderArchive FolderType = "Archive"
+	FolderAll     FolderType = "All"
+	FolderFlagged FolderType = "Flagged"
+	FolderOther   FolderType = "Other"
+)
+
+// specialUseTypes maps the SPECIAL-USE/LIST attributes defined in RFC 6154 to
+// our canonical FolderType.
+var specialUseTypes = map[string]FolderType{
+	specialuse.Sent:    FolderSent,
+	specialuse.Drafts:  FolderDrafts,
+	specialuse.Trash:   FolderTrash,
+	specialuse.Junk:    FolderJunk,
+	specialuse.Archive: FolderArchive,
+	specialuse.All:     FolderAll,
+	specialuse.Flagged: FolderFlagged,
+}
+
+// Folder describes one mailbox as returned by GetFolders: its raw IMAP name,
+// its canonical type, and enough metadata to render it in a mail client.
+type Folder struct {
+	Name       string
+	Type       FolderType
+	Delimiter  string
+	Subscribed bool
+}
+
+// GetFolders lists the mailboxes available on the server, resolving each
+// one's canonical Type from the SPECIAL-USE extension (RFC 6154) when the
+// server advertises it, falling back to LIST attribute flags and finally to
+// name heuristics. INBOX is always returned first.
+func (c *IMAPClient) GetFolders() ([]Folder, error) {
+	var folders []Folder
+
+	err := c.withConn(func(imapClient *client.Client) error {
+		mailboxes := make(chan *imap.MailboxInfo, 50)
+		done := make(chan error, 1)
+
+		go func() {
+			done <- imapClient.List("", "*", mailboxes)
+		}()
+
+		var infos []*imap.MailboxInfo
+		for m := range mailboxes {
+			infos = append(infos, m)
+		}
+
+		if err := <-done; err != nil {
+			return fmt.Errorf("failed to list mailboxes: %w", err)
+		}
+
+		subscribed, err := subscribedSet(imapClient)
+		if err != nil {
+			return err
+		}
+
+		folders = make([]Folder, 0, len(infos))
+		for _, m := range infos {
+			folders = append(folders, Folder{
+				Name:       m.Name,
+				Type:       resolveFolderType(m),
+				Delimiter:  m.Delimiter,
+				Subscribed: subscribed[m.Name],
+			})
+		}
+
+		sort.Slice(folders, func(i, j int) bool {
+			if folders[i].Name == "INBOX" {
+				return true
+			}
+			if folders[j].Name == "INBOX" {
+				return false
+			}
+			return folders[i].Name < folders[j].Name
+		})
+
+		return nil
+	})
+
+	return folders, err
+}
+
+// subscribedSet returns the set of mailbox names the account is subscribed
+// to, via LSUB.
+func subscribedSet(imapClient *client.Client) (map[string]bool, error) {
+	mailboxes := make(chan *imap.MailboxInfo, 50)
+	done := make(chan error, 1)
+
+	go func() {
+		done <- imapClient.Lsub("", "*", mailboxes)
+	}()
+
+	subscribed := make(map[string]bool)
+	for m := range mailboxes {
+		subscribed[m.Name] = true
+	}
+
+	if err := <-done; err != nil {
+		return nil, fmt.Errorf("failed to list subscribed mailboxes: %w", err)
+	}
+
+	return subscribed, nil
+}
+
+// resolveFolderType determines a mailbox's canonical type from its
+// SPECIAL-USE/LIST attributes, falling back to heuristics on its name.
+func resolveFolderType(m *imap.MailboxInfo) FolderType {
+	if m.Name == "INBOX" {
+		return FolderInbox
+	}
+
+	for _, attr := range m.Attributes {
+		if folderType, ok := specialUseTypes[attr]; ok {
+			return folderType
+		}
+	}
+
+	return folderTypeFromName(m.Name)
+}
+
+// folderTypeFromName is the last-resort fallback when a server advertises
+// neither SPECIAL-USE nor the older LIST attribute flags.
+func folderTypeFromName(name string) FolderType {
+	switch strings.ToLower(name) {
+	case "sent", "sent items", "sent messages":
+		return FolderSent
+	case "drafts":
+		return FolderDrafts
+	case "trash", "deleted items", "deleted messages":
+		return FolderTrash
+	case "junk", "spam", "junk e-mail":
+		return FolderJunk
+	case "archive", "all mail":
+		return FolderArchive
+	default:
+		return FolderOther
+	}
+}
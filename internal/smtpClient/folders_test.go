@@ -0,0 +1,80 @@
+package smtpclient
+
+import (
+	"testing"
+
+	"github.com/emersion/go-imap"
+	specialuse "github.com/emersion/go-imap-specialuse"
+)
+
+func TestResolveFolderType(t *testing.T) {
+	tests := []struct {
+		name string
+		m    *imap.MailboxInfo
+		want FolderType
+	}{
+		{
+			name: "inbox is always Inbox regardless of attributes",
+			m:    &imap.MailboxInfo{Name: "INBOX", Attributes: []string{specialuse.Junk}},
+			want: FolderInbox,
+		},
+		{
+			name: "SPECIAL-USE attribute wins over the name",
+			m:    &imap.MailboxInfo{Name: "Weird Folder Name", Attributes: []string{specialuse.Sent}},
+			want: FolderSent,
+		},
+		{
+			name: "unrecognized attribute falls back to name heuristics",
+			m:    &imap.MailboxInfo{Name: "Trash", Attributes: []string{"\\Nonexistent"}},
+			want: FolderTrash,
+		},
+		{
+			name: "no attributes falls back to name heuristics",
+			m:    &imap.MailboxInfo{Name: "Archive"},
+			want: FolderArchive,
+		},
+		{
+			name: "unmatched name and attributes is Other",
+			m:    &imap.MailboxInfo{Name: "Projects"},
+			want: FolderOther,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := resolveFolderType(tt.m); got != tt.want {
+				t.Errorf("resolveFolderType(%q) = %v, want %v", tt.m.Name, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFolderTypeFromName(t *testing.T) {
+	tests := []struct {
+		name string
+		want FolderType
+	}{
+		{"Sent", FolderSent},
+		{"Sent Items", FolderSent},
+		{"sent messages", FolderSent},
+		{"Drafts", FolderDrafts},
+		{"Trash", FolderTrash},
+		{"Deleted Items", FolderTrash},
+		{"deleted messages", FolderTrash},
+		{"Junk", FolderJunk},
+		{"Spam", FolderJunk},
+		{"Junk E-mail", FolderJunk},
+		{"Archive", FolderArchive},
+		{"All Mail", FolderArchive},
+		{"Newsletters", FolderOther},
+		{"", FolderOther},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := folderTypeFromName(tt.name); got != tt.want {
+				t.Errorf("folderTypeFromName(%q) = %v, want %v", tt.name, got, tt.want)
+			}
+		})
+	}
+}
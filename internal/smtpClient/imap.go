@@ -3,14 +3,12 @@ package smtpclient
 import (
 	"crypto/tls"
 	"fmt"
-	"io"
-	"io/ioutil"
 	"strconv"
 	"sync"
+	"time"
 
 	"github.com/emersion/go-imap"
 	"github.com/emersion/go-imap/client"
-	"github.com/emersion/go-message/mail"
 )
 
 // IMAPConfig holds the configuration for the IMAP client
@@ -19,13 +17,26 @@ type IMAPConfig struct {
 	Port     int
 	Username string
 	Password string
+
+	// PoolMinConns/PoolMaxConns/PoolIdleTimeout configure the connection
+	// pool opened by Connect. Zero values fall back to sensible defaults.
+	PoolMinConns    int
+	PoolMaxConns    int
+	PoolIdleTimeout time.Duration
 }
 
 // IMAPClient represents an IMAP client that can connect to a mail server
 type IMAPClient struct {
 	config IMAPConfig
-	client *client.Client
-	mu     sync.Mutex
+	pool   *connPool
+
+	// watchMu guards watchers, the registry of subscribers started by Watch.
+	watchMu  sync.Mutex
+	watchers map[string][]*watchSubscriber
+
+	// store, when set via SetMessageStore, lets GetInbox/GetFolderMessages/
+	// GetEmailByID read from the local cache instead of IMAP.
+	store *MessageStore
 }
 
 // NewIMAPClient creates a new IMAP client with the given configuration
@@ -35,19 +46,34 @@ func NewIMAPClient(config IMAPConfig) *IMAPClient {
 	}
 }
 
-// Connect establishes a connection to the IMAP server
+// Connect opens the connection pool used to serve requests. It dials and
+// authenticates one connection up front so bad credentials or an
+// unreachable host fail immediately rather than on the first request.
 func (c *IMAPClient) Connect() error {
-	c.mu.Lock()
-	defer c.mu.Unlock()
+	pool := newConnPool(c.config, c.config.PoolMinConns, c.config.PoolMaxConns, c.config.PoolIdleTimeout)
 
+	conn, err := pool.open()
+	if err != nil {
+		return err
+	}
+	pool.release(conn)
+
+	c.pool = pool
+	return nil
+}
+
+// dialAndLogin opens a new authenticated connection using config, without
+// touching any IMAPClient state. It's used both for the main connection and
+// for the extra connections Watch needs for IDLE.
+func dialAndLogin(config IMAPConfig) (*client.Client, error) {
 	var imapClient *client.Client
 	var err error
 
-	if c.config.Port == 1143 {
+	if config.Port == 1143 {
 
-		imapClient, err = client.Dial(fmt.Sprintf("%s:%d", c.config.Host, c.config.Port))
+		imapClient, err = client.Dial(fmt.Sprintf("%s:%d", config.Host, config.Port))
 		if err != nil {
-			return fmt.Errorf("failed to connect to IMAP server: %w", err)
+			return nil, fmt.Errorf("failed to connect to IMAP server: %w", err)
 		}
 
 		tlsConfig := &tls.Config{
@@ -55,73 +81,38 @@ func (c *IMAPClient) Connect() error {
 		}
 
 		if err := imapClient.StartTLS(tlsConfig); err != nil {
-			return fmt.Errorf("failed to start TLS: %w", err)
+			return nil, fmt.Errorf("failed to start TLS: %w", err)
 		}
 	} else {
 		tlsConfig := &tls.Config{
 			InsecureSkipVerify: true,
 		}
 
-		imapClient, err = client.DialTLS(fmt.Sprintf("%s:%d", c.config.Host, c.config.Port), tlsConfig)
+		imapClient, err = client.DialTLS(fmt.Sprintf("%s:%d", config.Host, config.Port), tlsConfig)
 		if err != nil {
-			return fmt.Errorf("failed to connect to IMAP server: %w", err)
+			return nil, fmt.Errorf("failed to connect to IMAP server: %w", err)
 		}
 	}
 
-	if err := imapClient.Login(c.config.Username, c.config.Password); err != nil {
+	if err := imapClient.Login(config.Username, config.Password); err != nil {
 		imapClient.Logout()
-		return fmt.Errorf("failed to login to IMAP server: %w", err)
+		return nil, fmt.Errorf("failed to login to IMAP server: %w", err)
 	}
 
-	c.client = imapClient
-	return nil
+	return imapClient, nil
 }
 
-// Disconnect closes the connection to the IMAP server
+// Disconnect closes every connection in the pool.
 func (c *IMAPClient) Disconnect() error {
-	c.mu.Lock()
-	defer c.mu.Unlock()
-
-	if c.client == nil {
+	if c.pool == nil {
 		return nil
 	}
 
-	if err := c.client.Logout(); err != nil {
-		return fmt.Errorf("failed to logout from IMAP server: %w", err)
-	}
-
-	c.client = nil
+	c.pool.closeAll()
+	c.pool = nil
 	return nil
 }
 
-// GetFolders retourne la liste des boîtes aux lettres (mailboxes) disponibles sur le serveur IMAP.
-func (c *IMAPClient) GetFolders() ([]string, error) {
-	c.mu.Lock()
-	defer c.mu.Unlock()
-
-	if c.client == nil {
-		return nil, fmt.Errorf("not connected to IMAP server")
-	}
-
-	mailboxes := make(chan *imap.MailboxInfo, 50)
-	done := make(chan error, 1)
-
-	go func() {
-		done <- c.client.List("", "*", mailboxes)
-	}()
-
-	var folderNames []string
-	for m := range mailboxes {
-		folderNames = append(folderNames, m.Name)
-	}
-
-	if err := <-done; err != nil {
-		return nil, fmt.Errorf("failed to list mailboxes: %w", err)
-	}
-
-	return folderNames, nil
-}
-
 // GetInboxResult represents the result of GetInbox operation
 type GetInboxResult struct {
 	Messages   []Message
@@ -130,85 +121,22 @@ type GetInboxResult struct {
 
 // GetInbox retrieves messages from the user's inbox with pagination
 func (c *IMAPClient) GetInbox(page, pageSize int) (*GetInboxResult, error) {
-	c.mu.Lock()
-	defer c.mu.Unlock()
-
-	if c.client == nil {
-		return nil, fmt.Errorf("not connected to IMAP server")
-	}
-
-	mbox, err := c.client.Select("INBOX", false)
-	if err != nil {
-		return nil, fmt.Errorf("failed to select inbox: %w", err)
-	}
-
-	totalCount := mbox.Messages
-
-	if totalCount == 0 {
-		return &GetInboxResult{
-			Messages:   []Message{},
-			TotalCount: 0,
-		}, nil
-	}
-
-	offset := (page - 1) * pageSize
-
-	if uint32(offset) >= totalCount {
-		return &GetInboxResult{
-			Messages:   []Message{},
-			TotalCount: totalCount,
-		}, nil
-	}
-
-	from := totalCount - uint32(offset)
-	to := from
-	if from > uint32(pageSize) {
-		to = from - uint32(pageSize) + 1
-	} else {
-		to = 1
-	}
-
-	seqSet := new(imap.SeqSet)
-	seqSet.AddRange(to, from)
-
-	items := []imap.FetchItem{imap.FetchEnvelope, imap.FetchFlags}
-	messages := make(chan *imap.Message, 10)
-	done := make(chan error, 1)
-
-	go func() {
-		done <- c.client.Fetch(seqSet, items, messages)
-	}()
-
-	fmt.Println("Fetching inbox messages")
-
-	var result []Message
-	for msg := range messages {
-		message := Message{
-			ID:      fmt.Sprintf("%d", msg.SeqNum),
-			Subject: msg.Envelope.Subject,
-			Date:    msg.Envelope.Date,
-			Flags:   msg.Flags,
-		}
-
-		if len(msg.Envelope.From) > 0 {
-			message.From = msg.Envelope.From[0].Address()
+	if c.store != nil {
+		result, ok, err := c.cachedFolderMessages("INBOX", page, pageSize)
+		if err != nil {
+			return nil, err
 		}
-
-		for _, addr := range msg.Envelope.To {
-			message.To = append(message.To, addr.Address())
+		if ok {
+			return &GetInboxResult{Messages: result.Messages, TotalCount: result.TotalCount}, nil
 		}
-
-		result = append(result, message)
 	}
 
-	if err := <-done; err != nil {
-		return nil, fmt.Errorf("failed to fetch messages: %w", err)
+	folderResult, err := c.fetchFolderPage("INBOX", page, pageSize)
+	if err != nil {
+		return nil, err
 	}
 
-	return &GetInboxResult{
-		Messages:   result,
-		TotalCount: totalCount,
-	}, nil
+	return &GetInboxResult{Messages: folderResult.Messages, TotalCount: folderResult.TotalCount}, nil
 }
 
 // GetFolderResult represents the result of GetFolderMessages operation
@@ -219,184 +147,181 @@ type GetFolderResult struct {
 
 // GetFolderMessages retrieves messages from a specific folder with pagination
 func (c *IMAPClient) GetFolderMessages(folder string, page, pageSize int) (*GetFolderResult, error) {
-	c.mu.Lock()
-	defer c.mu.Unlock()
-
-	if c.client == nil {
-		return nil, fmt.Errorf("not connected to IMAP server")
+	if c.store != nil {
+		result, ok, err := c.cachedFolderMessages(folder, page, pageSize)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			return result, nil
+		}
 	}
 
-	mbox, err := c.client.Select(folder, false)
-	if err != nil {
-		return nil, fmt.Errorf("failed to select folder: %w", err)
-	}
+	return c.fetchFolderPage(folder, page, pageSize)
+}
 
-	totalCount := mbox.Messages
+// fetchFolderPage fetches one page of folder straight from IMAP, newest
+// messages first. It backs both GetInbox and GetFolderMessages once the
+// local cache has nothing for the request.
+func (c *IMAPClient) fetchFolderPage(folder string, page, pageSize int) (*GetFolderResult, error) {
+	var result *GetFolderResult
 
-	if totalCount == 0 {
-		return &GetFolderResult{
-			Messages:   []Message{},
-			TotalCount: 0,
-		}, nil
-	}
+	err := c.withFolder(folder, func(imapClient *client.Client, mbox *imap.MailboxStatus) error {
+		totalCount := mbox.Messages
 
-	// Calculate the range of messages to fetch based on pagination parameters
-	// IMAP uses 1-based indexing, and messages are ordered from oldest to newest
-	// We want to fetch from newest to oldest, so we need to reverse the order
+		if totalCount == 0 {
+			result = &GetFolderResult{Messages: []Message{}, TotalCount: 0}
+			return nil
+		}
 
-	offset := (page - 1) * pageSize
+		// Calculate the range of messages to fetch based on pagination
+		// parameters. IMAP uses 1-based indexing, and messages are ordered
+		// from oldest to newest; we want newest to oldest, so the range is
+		// reversed.
+		offset := (page - 1) * pageSize
 
-	if uint32(offset) >= totalCount {
-		return &GetFolderResult{
-			Messages:   []Message{},
-			TotalCount: totalCount,
-		}, nil
-	}
+		if uint32(offset) >= totalCount {
+			result = &GetFolderResult{Messages: []Message{}, TotalCount: totalCount}
+			return nil
+		}
 
-	from := totalCount - uint32(offset)
-	to := from
-	if from > uint32(pageSize) {
-		to = from - uint32(pageSize) + 1
-	} else {
-		to = 1
-	}
+		from := totalCount - uint32(offset)
+		to := from
+		if from > uint32(pageSize) {
+			to = from - uint32(pageSize) + 1
+		} else {
+			to = 1
+		}
 
-	seqSet := new(imap.SeqSet)
-	seqSet.AddRange(to, from)
+		seqSet := new(imap.SeqSet)
+		seqSet.AddRange(to, from)
 
-	items := []imap.FetchItem{imap.FetchEnvelope, imap.FetchFlags}
-	messages := make(chan *imap.Message, 10)
-	done := make(chan error, 1)
+		items := []imap.FetchItem{imap.FetchUid, imap.FetchEnvelope, imap.FetchFlags}
+		messages := make(chan *imap.Message, 10)
+		done := make(chan error, 1)
 
-	go func() {
-		done <- c.client.Fetch(seqSet, items, messages)
-	}()
+		go func() {
+			done <- imapClient.Fetch(seqSet, items, messages)
+		}()
 
-	fmt.Println("Fetching messages from folder:", folder)
+		fmt.Println("Fetching messages from folder:", folder)
 
-	var result []Message
-	for msg := range messages {
-		message := Message{
-			ID:      fmt.Sprintf("%d", msg.SeqNum),
-			Subject: msg.Envelope.Subject,
-			Date:    msg.Envelope.Date,
-			Flags:   msg.Flags,
-		}
+		var fetched []Message
+		for msg := range messages {
+			message := Message{
+				ID:      fmt.Sprintf("%d", msg.Uid),
+				Subject: msg.Envelope.Subject,
+				Date:    msg.Envelope.Date,
+				Flags:   msg.Flags,
+			}
 
-		if len(msg.Envelope.From) > 0 {
-			message.From = msg.Envelope.From[0].Address()
-		}
+			if len(msg.Envelope.From) > 0 {
+				message.From = msg.Envelope.From[0].Address()
+			}
+
+			for _, addr := range msg.Envelope.To {
+				message.To = append(message.To, addr.Address())
+			}
 
-		for _, addr := range msg.Envelope.To {
-			message.To = append(message.To, addr.Address())
+			fetched = append(fetched, message)
 		}
 
-		result = append(result, message)
-	}
+		if err := <-done; err != nil {
+			return fmt.Errorf("failed to fetch messages: %w", err)
+		}
 
-	if err := <-done; err != nil {
-		return nil, fmt.Errorf("failed to fetch messages: %w", err)
-	}
+		result = &GetFolderResult{Messages: fetched, TotalCount: totalCount}
+		return nil
+	})
 
-	return &GetFolderResult{
-		Messages:   result,
-		TotalCount: totalCount,
-	}, nil
+	return result, err
 }
 
 // GetEmailByID retrieves a specific email by its ID with full details
 func (c *IMAPClient) GetEmailByID(id string, folder string) (*Message, error) {
-	c.mu.Lock()
-	defer c.mu.Unlock()
+	if folder == "" {
+		folder = "INBOX"
+	}
 
-	if c.client == nil {
-		return nil, fmt.Errorf("not connected to IMAP server")
+	if c.store != nil {
+		message, ok, err := c.cachedMessageByUID(folder, id)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			return message, nil
+		}
 	}
 
-	seqNum, err := strconv.ParseUint(id, 10, 32)
+	uid, err := strconv.ParseUint(id, 10, 32)
 	if err != nil {
 		return nil, fmt.Errorf("invalid email ID: %w", err)
 	}
 
-	if folder == "" {
-		folder = "INBOX"
-	}
-
-	fmt.Println("Selecting folder for email ID", id, ":", folder)
-	_, err = c.client.Select(folder, false)
-	if err != nil {
-		return nil, fmt.Errorf("failed to select folder %s: %w", folder, err)
-	}
+	var message *Message
 
-	seqSet := new(imap.SeqSet)
-	seqSet.AddNum(uint32(seqNum))
+	err = c.withFolder(folder, func(imapClient *client.Client, _ *imap.MailboxStatus) error {
+		fmt.Println("Selecting folder for email ID", id, ":", folder)
+
+		// Fetch by UID rather than sequence number: sequence numbers shift
+		// under concurrent expunges, which would otherwise silently return
+		// the wrong message.
+		seqSet := new(imap.SeqSet)
+		seqSet.AddNum(uint32(uid))
+
+		items := []imap.FetchItem{imap.FetchUid, imap.FetchEnvelope, imap.FetchFlags, imap.FetchBodyStructure, imap.FetchRFC822Size}
+		messages := make(chan *imap.Message, 1)
+		done := make(chan error, 1)
+
+		go func() {
+			done <- imapClient.UidFetch(seqSet, items, messages)
+		}()
+
+		var tree *IMAPPartNode
+		for msg := range messages {
+			message = &Message{
+				ID:      fmt.Sprintf("%d", msg.Uid),
+				Subject: msg.Envelope.Subject,
+				Date:    msg.Envelope.Date,
+				Flags:   msg.Flags,
+				Size:    msg.Size,
+			}
 
-	items := []imap.FetchItem{imap.FetchEnvelope, imap.FetchFlags, imap.FetchBodyStructure, imap.FetchRFC822Size, "BODY[]"}
-	messages := make(chan *imap.Message, 1)
-	done := make(chan error, 1)
+			if len(msg.Envelope.From) > 0 {
+				message.From = msg.Envelope.From[0].Address()
+			}
 
-	go func() {
-		done <- c.client.Fetch(seqSet, items, messages)
-	}()
+			for _, addr := range msg.Envelope.To {
+				message.To = append(message.To, addr.Address())
+			}
 
-	var message *Message
-	for msg := range messages {
-		message = &Message{
-			ID:      fmt.Sprintf("%d", msg.SeqNum),
-			Subject: msg.Envelope.Subject,
-			Date:    msg.Envelope.Date,
-			Flags:   msg.Flags,
-			Size:    msg.Size,
+			if msg.BodyStructure != nil {
+				tree = buildPartTree(msg.BodyStructure)
+			}
 		}
 
-		if len(msg.Envelope.From) > 0 {
-			message.From = msg.Envelope.From[0].Address()
+		if err := <-done; err != nil {
+			return fmt.Errorf("failed to fetch message: %w", err)
 		}
 
-		for _, addr := range msg.Envelope.To {
-			message.To = append(message.To, addr.Address())
+		if message == nil {
+			return fmt.Errorf("message with ID %s not found", id)
 		}
 
-		for _, literal := range msg.Body {
-			mr, err := mail.CreateReader(literal)
-			if err != nil {
-				continue
-			}
-
-			for {
-				p, err := mr.NextPart()
-				if err == io.EOF {
-					break
-				}
-				if err != nil {
-					continue
-				}
-
-				switch h := p.Header.(type) {
-				case *mail.InlineHeader:
-					b, _ := ioutil.ReadAll(p.Body)
-					message.Body = string(b)
-				case *mail.AttachmentHeader:
-					filename, _ := h.Filename()
-					b, _ := ioutil.ReadAll(p.Body)
-					contentType, _, _ := h.ContentType()
-
-					message.Attachments = append(message.Attachments, Attachment{
-						Filename: filename,
-						Content:  b,
-						MimeType: contentType,
-					})
-				}
+		// With the part tree in hand, fetch only the parts we actually
+		// need: the best text part for the body, and every attachment
+		// part. This avoids decoding the whole message just to read its
+		// envelope.
+		if tree != nil {
+			if err := populateBodyAndAttachments(imapClient, uint32(uid), folder, tree, message); err != nil {
+				return err
 			}
 		}
-	}
-
-	if err := <-done; err != nil {
-		return nil, fmt.Errorf("failed to fetch message: %w", err)
-	}
 
-	if message == nil {
-		return nil, fmt.Errorf("message with ID %s not found", id)
+		return nil
+	})
+	if err != nil {
+		return nil, err
 	}
 
 	return message, nil
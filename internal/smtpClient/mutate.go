@@ -0,0 +1,183 @@
+package smtpclient
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/emersion/go-imap"
+	uidplus "github.com/emersion/go-imap-uidplus"
+	"github.com/emersion/go-imap/client"
+	move "github.com/emersion/go-imap-move"
+)
+
+// FlagMode selects how SetFlags applies the given flags relative to a
+// message's existing flag set.
+type FlagMode int
+
+const (
+	// FlagsAdd adds flags without touching any flag already set (+FLAGS).
+	FlagsAdd FlagMode = iota
+	// FlagsRemove clears flags, leaving everything else untouched (-FLAGS).
+	FlagsRemove
+	// FlagsReplace overwrites the whole flag set (FLAGS).
+	FlagsReplace
+)
+
+func uidSeqSet(uids []uint32) *imap.SeqSet {
+	seqSet := new(imap.SeqSet)
+	seqSet.AddNum(uids...)
+	return seqSet
+}
+
+// SetFlags adds, removes or replaces flags on the given UIDs in folder.
+// Every mutation in this file is UID-based rather than sequence-number
+// based, so it stays correct even if another client expunges messages
+// concurrently.
+func (c *IMAPClient) SetFlags(folder string, uids []uint32, flags []string, mode FlagMode) error {
+	var item imap.StoreItem
+	switch mode {
+	case FlagsAdd:
+		item = imap.FormatFlagsOp(imap.AddFlags, true)
+	case FlagsRemove:
+		item = imap.FormatFlagsOp(imap.RemoveFlags, true)
+	default:
+		item = imap.FormatFlagsOp(imap.SetFlags, true)
+	}
+
+	flagsIface := make([]interface{}, len(flags))
+	for i, flag := range flags {
+		flagsIface[i] = flag
+	}
+
+	return c.withFolder(folder, func(imapClient *client.Client, _ *imap.MailboxStatus) error {
+		if err := imapClient.UidStore(uidSeqSet(uids), item, flagsIface, nil); err != nil {
+			return fmt.Errorf("failed to update flags: %w", err)
+		}
+		return nil
+	})
+}
+
+// MarkRead sets the \Seen flag on the given UIDs.
+func (c *IMAPClient) MarkRead(folder string, uids []uint32) error {
+	return c.SetFlags(folder, uids, []string{imap.SeenFlag}, FlagsAdd)
+}
+
+// MarkUnread clears the \Seen flag on the given UIDs.
+func (c *IMAPClient) MarkUnread(folder string, uids []uint32) error {
+	return c.SetFlags(folder, uids, []string{imap.SeenFlag}, FlagsRemove)
+}
+
+// MoveMessages moves uids from srcFolder to dstFolder, using the MOVE
+// extension (RFC 6851) when the server advertises it and falling back to
+// COPY + STORE \Deleted + EXPUNGE otherwise.
+func (c *IMAPClient) MoveMessages(srcFolder string, uids []uint32, dstFolder string) error {
+	return c.withFolder(srcFolder, func(imapClient *client.Client, _ *imap.MailboxStatus) error {
+		moveClient := move.NewClient(imapClient)
+		if err := moveClient.UidMoveWithFallback(uidSeqSet(uids), dstFolder); err != nil {
+			return fmt.Errorf("failed to move messages to %s: %w", dstFolder, err)
+		}
+		return nil
+	})
+}
+
+// CopyMessages copies uids from srcFolder to dstFolder, leaving the
+// originals in place.
+func (c *IMAPClient) CopyMessages(srcFolder string, uids []uint32, dstFolder string) error {
+	return c.withFolder(srcFolder, func(imapClient *client.Client, _ *imap.MailboxStatus) error {
+		if err := imapClient.UidCopy(uidSeqSet(uids), dstFolder); err != nil {
+			return fmt.Errorf("failed to copy messages to %s: %w", dstFolder, err)
+		}
+		return nil
+	})
+}
+
+// DeleteMessages flags uids \Deleted and expunges them. It uses UID EXPUNGE
+// (RFC 4315, the UIDPLUS extension) when the server supports it, so that
+// only the targeted messages are purged rather than every \Deleted message
+// in the folder.
+func (c *IMAPClient) DeleteMessages(folder string, uids []uint32) error {
+	return c.withFolder(folder, func(imapClient *client.Client, _ *imap.MailboxStatus) error {
+		deletedFlag := []interface{}{imap.DeletedFlag}
+		if err := imapClient.UidStore(uidSeqSet(uids), imap.FormatFlagsOp(imap.AddFlags, true), deletedFlag, nil); err != nil {
+			return fmt.Errorf("failed to flag messages for deletion: %w", err)
+		}
+
+		uidplusClient := uidplus.NewClient(imapClient)
+		supportsUidPlus, err := uidplusClient.SupportUidPlus()
+		if err != nil {
+			return fmt.Errorf("failed to check UIDPLUS support: %w", err)
+		}
+		if supportsUidPlus {
+			if err := uidplusClient.UidExpunge(uidSeqSet(uids), nil); err != nil {
+				return fmt.Errorf("failed to expunge messages: %w", err)
+			}
+			return nil
+		}
+
+		if err := imapClient.Expunge(nil); err != nil {
+			return fmt.Errorf("failed to expunge messages: %w", err)
+		}
+		return nil
+	})
+}
+
+// AppendMessage saves rfc822 (a full RFC 822 message) into folder with the
+// given flags and internal date, e.g. to save a draft or a sent copy.
+func (c *IMAPClient) AppendMessage(folder string, rfc822 imap.Literal, flags []string, date time.Time) error {
+	return c.withConn(func(imapClient *client.Client) error {
+		if err := imapClient.Append(folder, flags, date, rfc822); err != nil {
+			return fmt.Errorf("failed to append message to %s: %w", folder, err)
+		}
+		return nil
+	})
+}
+
+// CreateFolder creates a new mailbox.
+func (c *IMAPClient) CreateFolder(name string) error {
+	return c.withConn(func(imapClient *client.Client) error {
+		if err := imapClient.Create(name); err != nil {
+			return fmt.Errorf("failed to create folder %s: %w", name, err)
+		}
+		return nil
+	})
+}
+
+// RenameFolder renames a mailbox.
+func (c *IMAPClient) RenameFolder(oldName, newName string) error {
+	return c.withConn(func(imapClient *client.Client) error {
+		if err := imapClient.Rename(oldName, newName); err != nil {
+			return fmt.Errorf("failed to rename folder %s to %s: %w", oldName, newName, err)
+		}
+		return nil
+	})
+}
+
+// DeleteFolder removes a mailbox.
+func (c *IMAPClient) DeleteFolder(name string) error {
+	return c.withConn(func(imapClient *client.Client) error {
+		if err := imapClient.Delete(name); err != nil {
+			return fmt.Errorf("failed to delete folder %s: %w", name, err)
+		}
+		return nil
+	})
+}
+
+// Subscribe adds a mailbox to the user's active mailbox list.
+func (c *IMAPClient) Subscribe(name string) error {
+	return c.withConn(func(imapClient *client.Client) error {
+		if err := imapClient.Subscribe(name); err != nil {
+			return fmt.Errorf("failed to subscribe to folder %s: %w", name, err)
+		}
+		return nil
+	})
+}
+
+// Unsubscribe removes a mailbox from the user's active mailbox list.
+func (c *IMAPClient) Unsubscribe(name string) error {
+	return c.withConn(func(imapClient *client.Client) error {
+		if err := imapClient.Unsubscribe(name); err != nil {
+			return fmt.Errorf("failed to unsubscribe from folder %s: %w", name, err)
+		}
+		return nil
+	})
+}
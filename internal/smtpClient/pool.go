@@ -0,0 +1,264 @@
+package smtpclient
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/emersion/go-imap"
+	"github.com/emersion/go-imap/client"
+)
+
+const (
+	defaultPoolMinConns    = 1
+	defaultPoolMaxConns    = 4
+	defaultPoolIdleTimeout = 10 * time.Minute
+)
+
+// pooledConn wraps one authenticated IMAP connection with the bookkeeping
+// the pool needs to reuse it safely: when it was last handed back, and
+// which mailbox (if any) it currently has SELECTed.
+type pooledConn struct {
+	client          *client.Client
+	lastUsed        time.Time
+	selectedMailbox string
+}
+
+// connPool is a bounded pool of authenticated IMAP connections, so that one
+// slow request doesn't serialize every other request behind it. Acquiring a
+// connection verifies it's still alive with NOOP and transparently
+// reconnects it if the server dropped it.
+type connPool struct {
+	mu   sync.Mutex
+	cond *sync.Cond
+
+	config IMAPConfig
+
+	idle   []*pooledConn
+	numOut int
+
+	minSize     int
+	maxSize     int
+	idleTimeout time.Duration
+}
+
+func newConnPool(config IMAPConfig, minSize, maxSize int, idleTimeout time.Duration) *connPool {
+	if minSize <= 0 {
+		minSize = defaultPoolMinConns
+	}
+	if maxSize <= 0 {
+		maxSize = defaultPoolMaxConns
+	}
+	if maxSize < minSize {
+		maxSize = minSize
+	}
+	if idleTimeout <= 0 {
+		idleTimeout = defaultPoolIdleTimeout
+	}
+
+	pool := &connPool{
+		config:      config,
+		minSize:     minSize,
+		maxSize:     maxSize,
+		idleTimeout: idleTimeout,
+	}
+	pool.cond = sync.NewCond(&pool.mu)
+	return pool
+}
+
+// acquire returns a live, authenticated connection, preferring one already
+// SELECTed on folder (pass "" for no preference). It opens a new connection
+// while under maxSize, and otherwise blocks until one is released.
+func (p *connPool) acquire(folder string) (*pooledConn, error) {
+	p.mu.Lock()
+	for {
+		p.evictExpiredLocked()
+
+		if idx := p.pickIdleLocked(folder); idx >= 0 {
+			conn := p.idle[idx]
+			p.idle = append(p.idle[:idx], p.idle[idx+1:]...)
+			p.numOut++
+			p.mu.Unlock()
+
+			if err := conn.client.Noop(); err != nil {
+				reconnected, dialErr := p.redial(conn)
+				if dialErr != nil {
+					p.mu.Lock()
+					p.numOut--
+					p.mu.Unlock()
+					return nil, dialErr
+				}
+				return reconnected, nil
+			}
+			return conn, nil
+		}
+
+		if p.numOut+len(p.idle) < p.maxSize {
+			p.numOut++
+			p.mu.Unlock()
+
+			conn, err := p.open()
+			if err != nil {
+				p.mu.Lock()
+				p.numOut--
+				p.mu.Unlock()
+				return nil, err
+			}
+			return conn, nil
+		}
+
+		p.cond.Wait()
+	}
+}
+
+// release returns conn to the idle pool and wakes up any acquire waiting
+// for a free slot.
+func (p *connPool) release(conn *pooledConn) {
+	conn.lastUsed = time.Now()
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.numOut--
+	p.idle = append(p.idle, conn)
+	p.cond.Signal()
+}
+
+// invalidate drops conn instead of returning it to the pool, for use when a
+// caller has already observed it to be broken beyond a simple reconnect.
+func (p *connPool) invalidate(conn *pooledConn) {
+	if conn.client != nil {
+		conn.client.Logout()
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.numOut--
+	p.cond.Signal()
+}
+
+// closeAll logs out every idle connection. In-flight connections are left
+// for their callers to release; the next release will simply close over a
+// pool with no further users.
+func (p *connPool) closeAll() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	for _, conn := range p.idle {
+		conn.client.Logout()
+	}
+	p.idle = nil
+}
+
+// pickIdleLocked returns the index of the best idle connection to reuse:
+// one already SELECTed on folder if one exists, otherwise any idle
+// connection. Caller must hold p.mu.
+func (p *connPool) pickIdleLocked(folder string) int {
+	if folder != "" {
+		for i, conn := range p.idle {
+			if conn.selectedMailbox == folder {
+				return i
+			}
+		}
+	}
+	if len(p.idle) > 0 {
+		return 0
+	}
+	return -1
+}
+
+// evictExpiredLocked closes idle connections that have been sitting unused
+// past idleTimeout, as long as doing so doesn't drop the pool below
+// minSize. Caller must hold p.mu.
+func (p *connPool) evictExpiredLocked() {
+	kept, expired := partitionExpired(p.idle, p.numOut, p.minSize, p.idleTimeout, time.Now())
+	for _, conn := range expired {
+		conn.client.Logout()
+	}
+	p.idle = kept
+}
+
+// partitionExpired splits idle into connections worth keeping and ones past
+// idleTimeout that can be evicted without dropping numOut+len(idle) below
+// minSize. Split out from evictExpiredLocked so the eviction-count
+// arithmetic can be unit tested without a live IMAP connection.
+func partitionExpired(idle []*pooledConn, numOut, minSize int, idleTimeout time.Duration, now time.Time) (kept, expired []*pooledConn) {
+	remaining := numOut + len(idle)
+
+	kept = idle[:0]
+	for _, conn := range idle {
+		if remaining > minSize && now.Sub(conn.lastUsed) > idleTimeout {
+			expired = append(expired, conn)
+			remaining--
+			continue
+		}
+		kept = append(kept, conn)
+	}
+	return kept, expired
+}
+
+func (p *connPool) open() (*pooledConn, error) {
+	imapClient, err := dialAndLogin(p.config)
+	if err != nil {
+		return nil, err
+	}
+	return &pooledConn{client: imapClient, lastUsed: time.Now()}, nil
+}
+
+// redial replaces conn's underlying connection in place after a failed
+// health check (io.EOF, broken pipe, or the server having logged us out).
+func (p *connPool) redial(conn *pooledConn) (*pooledConn, error) {
+	imapClient, err := dialAndLogin(p.config)
+	if err != nil {
+		return nil, fmt.Errorf("failed to reconnect to IMAP server: %w", err)
+	}
+	conn.client = imapClient
+	conn.selectedMailbox = ""
+	conn.lastUsed = time.Now()
+	return conn, nil
+}
+
+// withConn runs fn against a pooled connection with no particular mailbox
+// selected, for operations like LIST or CREATE that aren't folder-scoped.
+func (c *IMAPClient) withConn(fn func(*client.Client) error) error {
+	if c.pool == nil {
+		return fmt.Errorf("not connected to IMAP server")
+	}
+
+	conn, err := c.pool.acquire("")
+	if err != nil {
+		return fmt.Errorf("failed to acquire IMAP connection: %w", err)
+	}
+	defer c.pool.release(conn)
+
+	return fn(conn.client)
+}
+
+// withFolder runs fn against a pooled connection SELECTed on folder,
+// reusing a connection already on that folder when one is available so the
+// common case of paging through the same mailbox doesn't redo SELECT on
+// every call.
+func (c *IMAPClient) withFolder(folder string, fn func(imapClient *client.Client, mbox *imap.MailboxStatus) error) error {
+	if c.pool == nil {
+		return fmt.Errorf("not connected to IMAP server")
+	}
+
+	conn, err := c.pool.acquire(folder)
+	if err != nil {
+		return fmt.Errorf("failed to acquire IMAP connection: %w", err)
+	}
+	defer c.pool.release(conn)
+
+	var mbox *imap.MailboxStatus
+	if conn.selectedMailbox == folder {
+		mbox = conn.client.Mailbox()
+	} else {
+		mbox, err = conn.client.Select(folder, false)
+		if err != nil {
+			return fmt.Errorf("failed to select folder %s: %w", folder, err)
+		}
+		conn.selectedMailbox = folder
+	}
+
+	return fn(conn.client, mbox)
+}
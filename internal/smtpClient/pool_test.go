@@ -0,0 +1,121 @@
+package smtpclient
+
+import (
+	"testing"
+	"time"
+)
+
+func TestPickIdleLocked(t *testing.T) {
+	inbox := &pooledConn{selectedMailbox: "INBOX"}
+	sent := &pooledConn{selectedMailbox: "Sent"}
+
+	tests := []struct {
+		name   string
+		idle   []*pooledConn
+		folder string
+		want   int
+	}{
+		{name: "empty pool returns -1", idle: nil, folder: "INBOX", want: -1},
+		{name: "no folder preference returns the first idle conn", idle: []*pooledConn{sent, inbox}, folder: "", want: 0},
+		{name: "prefers a conn already selected on folder", idle: []*pooledConn{sent, inbox}, folder: "INBOX", want: 1},
+		{name: "falls back to any idle conn when none match folder", idle: []*pooledConn{sent}, folder: "INBOX", want: 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := &connPool{idle: tt.idle}
+			if got := p.pickIdleLocked(tt.folder); got != tt.want {
+				t.Errorf("pickIdleLocked(%q) = %d, want %d", tt.folder, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPartitionExpiredRespectsMinSize(t *testing.T) {
+	now := time.Now()
+	expiredAt := now.Add(-time.Hour)
+	idleTimeout := time.Minute
+
+	conns := func(n int, lastUsed time.Time) []*pooledConn {
+		out := make([]*pooledConn, n)
+		for i := range out {
+			out[i] = &pooledConn{lastUsed: lastUsed}
+		}
+		return out
+	}
+
+	tests := []struct {
+		name        string
+		idle        []*pooledConn
+		numOut      int
+		minSize     int
+		wantKept    int
+		wantExpired int
+	}{
+		{
+			name:        "evicting all expired conns would drop below minSize, so only the excess is evicted",
+			idle:        conns(3, expiredAt),
+			numOut:      0,
+			minSize:     2,
+			wantKept:    2,
+			wantExpired: 1,
+		},
+		{
+			name:        "nothing is evicted when every conn is within idleTimeout",
+			idle:        conns(3, now),
+			numOut:      0,
+			minSize:     0,
+			wantKept:    3,
+			wantExpired: 0,
+		},
+		{
+			name:        "in-flight conns count toward minSize, so all idle conns can be evicted",
+			idle:        conns(2, expiredAt),
+			numOut:      2,
+			minSize:     2,
+			wantKept:    0,
+			wantExpired: 2,
+		},
+		{
+			name:        "minSize of zero evicts every expired conn",
+			idle:        conns(3, expiredAt),
+			numOut:      0,
+			minSize:     0,
+			wantKept:    0,
+			wantExpired: 3,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			kept, expired := partitionExpired(tt.idle, tt.numOut, tt.minSize, idleTimeout, now)
+			if len(kept) != tt.wantKept {
+				t.Errorf("len(kept) = %d, want %d", len(kept), tt.wantKept)
+			}
+			if len(expired) != tt.wantExpired {
+				t.Errorf("len(expired) = %d, want %d", len(expired), tt.wantExpired)
+			}
+			if tt.numOut+len(kept) < tt.minSize && len(tt.idle) > 0 {
+				t.Errorf("pool dropped below minSize: numOut=%d kept=%d minSize=%d", tt.numOut, len(kept), tt.minSize)
+			}
+		})
+	}
+}
+
+func TestPoolReleaseReturnsConnToIdle(t *testing.T) {
+	p := newConnPool(IMAPConfig{}, 1, 4, time.Minute)
+	p.numOut = 1
+
+	conn := &pooledConn{}
+	p.release(conn)
+
+	if p.numOut != 0 {
+		t.Errorf("numOut = %d, want 0", p.numOut)
+	}
+	if len(p.idle) != 1 || p.idle[0] != conn {
+		t.Errorf("idle = %v, want [conn]", p.idle)
+	}
+	if conn.lastUsed.IsZero() {
+		t.Error("release did not update lastUsed")
+	}
+}
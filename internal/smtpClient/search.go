@@ -0,0 +1,292 @@
+package smtpclient
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/emersion/go-imap"
+	sortthread "github.com/emersion/go-imap-sortthread"
+	"github.com/emersion/go-imap/client"
+)
+
+// SearchCriteria describes a server-side IMAP SEARCH query. Empty fields are
+// omitted from the query sent to the server.
+type SearchCriteria struct {
+	From    string
+	To      string
+	Subject string
+	Body    string
+
+	Since  time.Time
+	Before time.Time
+
+	WithFlags    []string // e.g. imap.SeenFlag, imap.FlaggedFlag, imap.AnsweredFlag
+	WithoutFlags []string
+
+	MinSize uint32
+	MaxSize uint32
+
+	// Text is matched against the whole message (IMAP TEXT key) and is meant
+	// for a free-form search box rather than a specific field.
+	Text string
+}
+
+// toIMAPCriteria translates SearchCriteria into the criteria type the
+// underlying go-imap client expects.
+func (sc SearchCriteria) toIMAPCriteria() *imap.SearchCriteria {
+	criteria := imap.NewSearchCriteria()
+
+	if sc.From != "" {
+		criteria.Header.Add("From", sc.From)
+	}
+	if sc.To != "" {
+		criteria.Header.Add("To", sc.To)
+	}
+	if sc.Subject != "" {
+		criteria.Header.Add("Subject", sc.Subject)
+	}
+	if sc.Body != "" {
+		criteria.Body = append(criteria.Body, sc.Body)
+	}
+	if sc.Text != "" {
+		criteria.Text = append(criteria.Text, sc.Text)
+	}
+	if !sc.Since.IsZero() {
+		criteria.Since = sc.Since
+	}
+	if !sc.Before.IsZero() {
+		criteria.Before = sc.Before
+	}
+	if sc.MinSize > 0 {
+		criteria.Larger = sc.MinSize
+	}
+	if sc.MaxSize > 0 {
+		criteria.Smaller = sc.MaxSize
+	}
+	criteria.WithFlags = append(criteria.WithFlags, sc.WithFlags...)
+	criteria.WithoutFlags = append(criteria.WithoutFlags, sc.WithoutFlags...)
+
+	return criteria
+}
+
+// SortField picks which envelope field SearchMessages orders results by.
+type SortField string
+
+const (
+	SortByDate    SortField = "date"
+	SortBySubject SortField = "subject"
+	SortByFrom    SortField = "from"
+	SortBySize    SortField = "size"
+)
+
+// SortOptions controls the ordering of SearchMessages results.
+type SortOptions struct {
+	Field   SortField
+	Reverse bool
+}
+
+// SearchResult is the paginated, sorted outcome of SearchMessages.
+type SearchResult struct {
+	Messages   []Message
+	TotalCount uint32
+}
+
+// SearchMessages runs a server-side IMAP SEARCH (using SORT when the server
+// advertises it, per RFC 5256) against folder, then fetches envelopes for
+// only the UIDs in the requested page. This avoids fetching whole sequence
+// ranges just to filter or order them client-side.
+func (c *IMAPClient) SearchMessages(folder string, criteria SearchCriteria, sortOpts SortOptions, page, pageSize int) (*SearchResult, error) {
+	var result *SearchResult
+
+	err := c.withFolder(folder, func(imapClient *client.Client, _ *imap.MailboxStatus) error {
+		imapCriteria := criteria.toIMAPCriteria()
+
+		uids, err := sortedUIDs(imapClient, imapCriteria, sortOpts)
+		if err != nil {
+			return err
+		}
+
+		totalCount := uint32(len(uids))
+		if totalCount == 0 {
+			result = &SearchResult{Messages: []Message{}, TotalCount: 0}
+			return nil
+		}
+
+		offset := (page - 1) * pageSize
+		if offset >= len(uids) {
+			result = &SearchResult{Messages: []Message{}, TotalCount: totalCount}
+			return nil
+		}
+
+		end := offset + pageSize
+		if end > len(uids) {
+			end = len(uids)
+		}
+		pageUIDs := uids[offset:end]
+
+		seqSet := new(imap.SeqSet)
+		for _, uid := range pageUIDs {
+			seqSet.AddNum(uid)
+		}
+
+		items := []imap.FetchItem{imap.FetchUid, imap.FetchEnvelope, imap.FetchFlags}
+		messages := make(chan *imap.Message, 10)
+		done := make(chan error, 1)
+
+		go func() {
+			done <- imapClient.UidFetch(seqSet, items, messages)
+		}()
+
+		byUID := make(map[uint32]Message, len(pageUIDs))
+		for msg := range messages {
+			message := Message{
+				ID:      fmt.Sprintf("%d", msg.Uid),
+				Subject: msg.Envelope.Subject,
+				Date:    msg.Envelope.Date,
+				Flags:   msg.Flags,
+			}
+
+			if len(msg.Envelope.From) > 0 {
+				message.From = msg.Envelope.From[0].Address()
+			}
+			for _, addr := range msg.Envelope.To {
+				message.To = append(message.To, addr.Address())
+			}
+
+			byUID[msg.Uid] = message
+		}
+
+		if err := <-done; err != nil {
+			return fmt.Errorf("failed to fetch search results: %w", err)
+		}
+
+		pageMessages := make([]Message, 0, len(pageUIDs))
+		for _, uid := range pageUIDs {
+			if msg, ok := byUID[uid]; ok {
+				pageMessages = append(pageMessages, msg)
+			}
+		}
+
+		result = &SearchResult{Messages: pageMessages, TotalCount: totalCount}
+		return nil
+	})
+
+	return result, err
+}
+
+// sortedUIDs returns the UIDs matching criteria, ordered per sortOpts. It
+// uses the SORT extension (RFC 5256) when the server advertises it, falling
+// back to a plain UID SEARCH followed by an in-memory sort by envelope. The
+// caller must already have SELECTed the right folder on imapClient.
+func sortedUIDs(imapClient *client.Client, criteria *imap.SearchCriteria, sortOpts SortOptions) ([]uint32, error) {
+	sortClient := sortthread.NewSortClient(imapClient)
+
+	supportsSort, err := sortClient.SupportSort()
+	if err != nil {
+		return nil, fmt.Errorf("failed to check SORT support: %w", err)
+	}
+	if supportsSort {
+		sortCriteria := []sortthread.SortCriterion{imapSortCriterion(sortOpts)}
+		uids, err := sortClient.UidSort(sortCriteria, criteria)
+		if err != nil {
+			return nil, fmt.Errorf("failed to sort messages: %w", err)
+		}
+		return uids, nil
+	}
+
+	uids, err := imapClient.UidSearch(criteria)
+	if err != nil {
+		return nil, fmt.Errorf("failed to search messages: %w", err)
+	}
+
+	return sortUIDsByEnvelope(imapClient, uids, sortOpts)
+}
+
+// imapSortCriterion maps our SortOptions onto the go-imap-sortthread type.
+func imapSortCriterion(sortOpts SortOptions) sortthread.SortCriterion {
+	var field sortthread.SortField
+	switch sortOpts.Field {
+	case SortBySubject:
+		field = sortthread.SortSubject
+	case SortByFrom:
+		field = sortthread.SortFrom
+	case SortBySize:
+		field = sortthread.SortSize
+	default:
+		field = sortthread.SortDate
+	}
+	return sortthread.SortCriterion{Field: field, Reverse: sortOpts.Reverse}
+}
+
+// sortUIDsByEnvelope is the fallback path for servers without the SORT
+// extension: fetch envelopes for the matched UIDs and sort them in memory.
+// The caller must already have SELECTed the right folder on imapClient.
+func sortUIDsByEnvelope(imapClient *client.Client, uids []uint32, sortOpts SortOptions) ([]uint32, error) {
+	if len(uids) == 0 {
+		return nil, nil
+	}
+
+	seqSet := new(imap.SeqSet)
+	seqSet.AddNum(uids...)
+
+	items := []imap.FetchItem{imap.FetchUid, imap.FetchEnvelope}
+	messages := make(chan *imap.Message, 10)
+	done := make(chan error, 1)
+
+	go func() {
+		done <- imapClient.UidFetch(seqSet, items, messages)
+	}()
+
+	entries := make([]envelopeEntry, 0, len(uids))
+	for msg := range messages {
+		entry := envelopeEntry{uid: msg.Uid, date: msg.Envelope.Date, subject: msg.Envelope.Subject, size: msg.Size}
+		if len(msg.Envelope.From) > 0 {
+			entry.from = msg.Envelope.From[0].Address()
+		}
+		entries = append(entries, entry)
+	}
+
+	if err := <-done; err != nil {
+		return nil, fmt.Errorf("failed to fetch envelopes for sorting: %w", err)
+	}
+
+	entries = sortEnvelopeEntries(entries, sortOpts)
+
+	result := make([]uint32, len(entries))
+	for i, entry := range entries {
+		result[i] = entry.uid
+	}
+	return result, nil
+}
+
+// envelopeEntry holds the envelope fields sortEnvelopeEntries can order by.
+type envelopeEntry struct {
+	uid     uint32
+	date    time.Time
+	subject string
+	from    string
+	size    uint32
+}
+
+// sortEnvelopeEntries orders entries per sortOpts. Split out from
+// sortUIDsByEnvelope so the comparator can be unit tested without a live
+// IMAP connection.
+func sortEnvelopeEntries(entries []envelopeEntry, sortOpts SortOptions) []envelopeEntry {
+	sort.Slice(entries, func(i, j int) bool {
+		if sortOpts.Reverse {
+			i, j = j, i
+		}
+		switch sortOpts.Field {
+		case SortBySubject:
+			return entries[i].subject < entries[j].subject
+		case SortByFrom:
+			return entries[i].from < entries[j].from
+		case SortBySize:
+			return entries[i].size < entries[j].size
+		default:
+			return entries[i].date.Before(entries[j].date)
+		}
+	})
+	return entries
+}
@@ -0,0 +1,80 @@
+package smtpclient
+
+import (
+	"testing"
+	"time"
+)
+
+func TestSortUIDsByEnvelope(t *testing.T) {
+	jan1 := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	jan2 := time.Date(2026, 1, 2, 0, 0, 0, 0, time.UTC)
+
+	entries := []envelopeEntry{
+		{uid: 1, date: jan2, subject: "b", from: "bob@example.com", size: 200},
+		{uid: 2, date: jan1, subject: "a", from: "alice@example.com", size: 100},
+		{uid: 3, date: jan1, subject: "a", from: "alice@example.com", size: 100},
+	}
+
+	tests := []struct {
+		name    string
+		field   SortField
+		reverse bool
+	}{
+		{name: "date ascending", field: SortByDate, reverse: false},
+		{name: "date descending", field: SortByDate, reverse: true},
+		{name: "subject ascending", field: SortBySubject, reverse: false},
+		{name: "subject descending", field: SortBySubject, reverse: true},
+		{name: "from ascending", field: SortByFrom, reverse: false},
+		{name: "from descending", field: SortByFrom, reverse: true},
+		{name: "size ascending", field: SortBySize, reverse: false},
+		{name: "size descending", field: SortBySize, reverse: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sorted := sortEnvelopeEntries(append([]envelopeEntry(nil), entries...), SortOptions{Field: tt.field, Reverse: tt.reverse})
+			if len(sorted) != len(entries) {
+				t.Fatalf("len(sorted) = %d, want %d", len(sorted), len(entries))
+			}
+
+			// uid 2 and uid 3 compare equal on every field, so only the
+			// position of the distinct uid (1), which sorts highest, is checked.
+			wantFirst := uint32(2)
+			if tt.reverse {
+				wantFirst = 1
+			}
+			if sorted[0].uid != wantFirst {
+				t.Errorf("sorted[0].uid = %d, want %d (full: %v)", sorted[0].uid, wantFirst, sorted)
+			}
+		})
+	}
+}
+
+// TestSortUIDsByEnvelopeTiesAreStrictWeakOrdering guards against the
+// comparator violating strict weak ordering when entries compare equal
+// (the bug: negating the forward comparator for Reverse instead of swapping
+// the compared operands returns true in both directions for a tied pair).
+func TestSortUIDsByEnvelopeTiesAreStrictWeakOrdering(t *testing.T) {
+	same := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	entries := []envelopeEntry{
+		{uid: 1, date: same, subject: "re: thread", size: 100},
+		{uid: 2, date: same, subject: "re: thread", size: 100},
+		{uid: 3, date: same, subject: "re: thread", size: 100},
+	}
+
+	for _, reverse := range []bool{false, true} {
+		sorted := sortEnvelopeEntries(append([]envelopeEntry(nil), entries...), SortOptions{Field: SortByDate, Reverse: reverse})
+		if len(sorted) != len(entries) {
+			t.Fatalf("reverse=%v: len(sorted) = %d, want %d", reverse, len(sorted), len(entries))
+		}
+		seen := make(map[uint32]bool, len(sorted))
+		for _, entry := range sorted {
+			seen[entry.uid] = true
+		}
+		for _, entry := range entries {
+			if !seen[entry.uid] {
+				t.Errorf("reverse=%v: uid %d missing from sorted output %v", reverse, entry.uid, sorted)
+			}
+		}
+	}
+}
@@ -0,0 +1,234 @@
+package smtpclient
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"go.etcd.io/bbolt"
+)
+
+// stateBucket holds one key per folder with its folderState, so Sync can
+// tell whether a cache is fresh, stale, or needs a full rebuild.
+const stateBucket = "folder_state"
+
+// folderState is what Sync needs to remember between runs to decide whether
+// it can do an incremental sync or must start over.
+type folderState struct {
+	UIDValidity   uint32 `json:"uid_validity"`
+	HighestModSeq uint64 `json:"highest_mod_seq"`
+	LastUID       uint32 `json:"last_uid"`
+}
+
+// cachedMessage is the on-disk representation of a cached message. It mirrors
+// Message, minus the Body/Attachments payload which is stored separately so
+// that listing cached messages doesn't have to decode attachment bytes.
+type cachedMessage struct {
+	UID     uint32    `json:"uid"`
+	Subject string    `json:"subject"`
+	From    string    `json:"from"`
+	To      []string  `json:"to"`
+	Date    time.Time `json:"date"`
+	Flags   []string  `json:"flags"`
+	Size    uint32    `json:"size"`
+	Body    string    `json:"body,omitempty"`
+}
+
+// MessageStore caches IMAP envelopes, flags and bodies on disk, keyed by
+// (mailbox, UIDVALIDITY, UID), so that paginated reads don't have to hit the
+// IMAP server on every request.
+type MessageStore struct {
+	db *bbolt.DB
+}
+
+// NewMessageStore opens (creating if necessary) a BoltDB-backed message
+// store at path.
+func NewMessageStore(path string) (*MessageStore, error) {
+	db, err := bbolt.Open(path, 0600, &bbolt.Options{Timeout: 1 * time.Second})
+	if err != nil {
+		return nil, fmt.Errorf("failed to open message store: %w", err)
+	}
+
+	if err := db.Update(func(tx *bbolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists([]byte(stateBucket))
+		return err
+	}); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to initialize message store: %w", err)
+	}
+
+	return &MessageStore{db: db}, nil
+}
+
+// Close releases the underlying database handle.
+func (s *MessageStore) Close() error {
+	return s.db.Close()
+}
+
+// folderBucket returns the bucket name messages for (folder, uidValidity)
+// are stored under. UIDs are only unique within a given UIDVALIDITY, so the
+// bucket is scoped to it; when UIDVALIDITY changes the old bucket is simply
+// abandoned and dropped by invalidateFolder.
+func folderBucket(folder string, uidValidity uint32) string {
+	return fmt.Sprintf("messages/%s/%d", folder, uidValidity)
+}
+
+func uidKey(uid uint32) []byte {
+	key := make([]byte, 4)
+	binary.BigEndian.PutUint32(key, uid)
+	return key
+}
+
+// getState returns the last known sync state for folder, or the zero value
+// and ok=false if the folder has never been synced.
+func (s *MessageStore) getState(folder string) (folderState, bool, error) {
+	var state folderState
+	var ok bool
+
+	err := s.db.View(func(tx *bbolt.Tx) error {
+		bucket := tx.Bucket([]byte(stateBucket))
+		data := bucket.Get([]byte(folder))
+		if data == nil {
+			return nil
+		}
+		ok = true
+		return json.Unmarshal(data, &state)
+	})
+
+	return state, ok, err
+}
+
+// saveState persists the sync state for folder.
+func (s *MessageStore) saveState(folder string, state folderState) error {
+	data, err := json.Marshal(state)
+	if err != nil {
+		return err
+	}
+
+	return s.db.Update(func(tx *bbolt.Tx) error {
+		bucket := tx.Bucket([]byte(stateBucket))
+		return bucket.Put([]byte(folder), data)
+	})
+}
+
+// invalidateFolder drops every cached message for folder under the given
+// UIDVALIDITY, used when the server reports a new UIDVALIDITY.
+func (s *MessageStore) invalidateFolder(folder string, uidValidity uint32) error {
+	return s.db.Update(func(tx *bbolt.Tx) error {
+		return tx.DeleteBucket([]byte(folderBucket(folder, uidValidity)))
+	})
+}
+
+// putMessages upserts a batch of cached messages for (folder, uidValidity).
+func (s *MessageStore) putMessages(folder string, uidValidity uint32, messages []cachedMessage) error {
+	return s.db.Update(func(tx *bbolt.Tx) error {
+		bucket, err := tx.CreateBucketIfNotExists([]byte(folderBucket(folder, uidValidity)))
+		if err != nil {
+			return err
+		}
+
+		for _, msg := range messages {
+			data, err := json.Marshal(msg)
+			if err != nil {
+				return err
+			}
+			if err := bucket.Put(uidKey(msg.UID), data); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// updateFlags overwrites just the Flags field of already-cached messages.
+func (s *MessageStore) updateFlags(folder string, uidValidity uint32, flagsByUID map[uint32][]string) error {
+	return s.db.Update(func(tx *bbolt.Tx) error {
+		bucket := tx.Bucket([]byte(folderBucket(folder, uidValidity)))
+		if bucket == nil {
+			return nil
+		}
+
+		for uid, flags := range flagsByUID {
+			data := bucket.Get(uidKey(uid))
+			if data == nil {
+				continue
+			}
+			var msg cachedMessage
+			if err := json.Unmarshal(data, &msg); err != nil {
+				return err
+			}
+			msg.Flags = flags
+			updated, err := json.Marshal(msg)
+			if err != nil {
+				return err
+			}
+			if err := bucket.Put(uidKey(uid), updated); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// deleteMessages removes cached messages for the given UIDs (used when the
+// server reports VANISHED or an equivalent expunge).
+func (s *MessageStore) deleteMessages(folder string, uidValidity uint32, uids []uint32) error {
+	return s.db.Update(func(tx *bbolt.Tx) error {
+		bucket := tx.Bucket([]byte(folderBucket(folder, uidValidity)))
+		if bucket == nil {
+			return nil
+		}
+		for _, uid := range uids {
+			if err := bucket.Delete(uidKey(uid)); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// listMessages returns every cached message for (folder, uidValidity),
+// ordered by UID ascending.
+func (s *MessageStore) listMessages(folder string, uidValidity uint32) ([]cachedMessage, error) {
+	var messages []cachedMessage
+
+	err := s.db.View(func(tx *bbolt.Tx) error {
+		bucket := tx.Bucket([]byte(folderBucket(folder, uidValidity)))
+		if bucket == nil {
+			return nil
+		}
+		return bucket.ForEach(func(_, data []byte) error {
+			var msg cachedMessage
+			if err := json.Unmarshal(data, &msg); err != nil {
+				return err
+			}
+			messages = append(messages, msg)
+			return nil
+		})
+	})
+
+	return messages, err
+}
+
+// getMessage returns a single cached message by UID, or ok=false if it isn't
+// cached.
+func (s *MessageStore) getMessage(folder string, uidValidity uint32, uid uint32) (cachedMessage, bool, error) {
+	var msg cachedMessage
+	var ok bool
+
+	err := s.db.View(func(tx *bbolt.Tx) error {
+		bucket := tx.Bucket([]byte(folderBucket(folder, uidValidity)))
+		if bucket == nil {
+			return nil
+		}
+		data := bucket.Get(uidKey(uid))
+		if data == nil {
+			return nil
+		}
+		ok = true
+		return json.Unmarshal(data, &msg)
+	})
+
+	return msg, ok, err
+}
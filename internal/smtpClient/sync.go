@@ -0,0 +1,619 @@
+package smtpclient
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/emersion/go-imap"
+	"github.com/emersion/go-imap/client"
+	"github.com/emersion/go-imap/responses"
+)
+
+// condstoreFetchCommand issues `UID FETCH <seqset> <items> (CHANGEDSINCE
+// <modseq>)`, which isn't part of the base go-imap command set. It follows
+// the same pattern as the other extension commands in this package: a small
+// type implementing imap.Commander.
+type condstoreFetchCommand struct {
+	SeqSet       *imap.SeqSet
+	Items        []imap.FetchItem
+	ChangedSince uint64
+}
+
+func (cmd *condstoreFetchCommand) Command() *imap.Command {
+	items := make([]interface{}, len(cmd.Items))
+	for i, item := range cmd.Items {
+		items[i] = item
+	}
+
+	return &imap.Command{
+		Name: "UID FETCH",
+		Arguments: []interface{}{
+			cmd.SeqSet,
+			items,
+			imap.RawString(fmt.Sprintf("(CHANGEDSINCE %d)", cmd.ChangedSince)),
+		},
+	}
+}
+
+// condstoreSelectCommand issues `SELECT <folder> (CONDSTORE)` so the server
+// includes HIGHESTMODSEQ in its response, which a plain SELECT isn't
+// required to send.
+type condstoreSelectCommand struct {
+	Folder string
+}
+
+func (cmd *condstoreSelectCommand) Command() *imap.Command {
+	return &imap.Command{
+		Name:      "SELECT",
+		Arguments: []interface{}{imap.RawString(cmd.Folder), imap.RawString("(CONDSTORE)")},
+	}
+}
+
+// qresyncSelectCommand issues `SELECT <folder> (QRESYNC (<uidValidity>
+// <modSeq> <knownUIDs>))` so the server can reply with VANISHED/FETCH
+// responses for everything that changed since modSeq, in one round trip.
+type qresyncSelectCommand struct {
+	Folder      string
+	UIDValidity uint32
+	ModSeq      uint64
+	KnownUIDs   *imap.SeqSet
+}
+
+func (cmd *qresyncSelectCommand) Command() *imap.Command {
+	qresyncParams := imap.RawString(fmt.Sprintf("(QRESYNC (%d %d %s))", cmd.UIDValidity, cmd.ModSeq, cmd.KnownUIDs.String()))
+	return &imap.Command{
+		Name:      "SELECT",
+		Arguments: []interface{}{imap.RawString(cmd.Folder), qresyncParams},
+	}
+}
+
+// modSeqSelectResponse wraps responses.Select to additionally capture the
+// two pieces of CONDSTORE/QRESYNC (RFC 7162) data the base go-imap library
+// has no built-in support for: the HIGHESTMODSEQ status code sent with every
+// CONDSTORE-enabled SELECT, and the VANISHED (EARLIER) <uid-set> response
+// QRESYNC sends for messages expunged since the client's last known modseq.
+type modSeqSelectResponse struct {
+	sel           responses.Select
+	HighestModSeq uint64
+	VanishedUIDs  []uint32
+}
+
+func (r *modSeqSelectResponse) Handle(resp imap.Resp) error {
+	switch resp := resp.(type) {
+	case *imap.StatusResp:
+		if resp.Code == "HIGHESTMODSEQ" && len(resp.Arguments) > 0 {
+			modSeq, err := parseModSeq(resp.Arguments[0])
+			if err != nil {
+				return err
+			}
+			r.HighestModSeq = modSeq
+			return nil
+		}
+	case *imap.DataResp:
+		if name, fields, ok := imap.ParseNamedResp(resp); ok && name == "VANISHED" {
+			uids, err := parseVanishedUIDs(fields)
+			if err != nil {
+				return err
+			}
+			r.VanishedUIDs = append(r.VanishedUIDs, uids...)
+			return nil
+		}
+	}
+	return r.sel.Handle(resp)
+}
+
+// parseModSeq parses a mod-sequence-value (RFC 7162 section 3.1.1), which
+// unlike most IMAP numbers can exceed 32 bits, so imap.ParseNumber can't be
+// reused here.
+func parseModSeq(f interface{}) (uint64, error) {
+	s, err := imap.ParseString(f)
+	if err != nil {
+		return 0, err
+	}
+	return strconv.ParseUint(s, 10, 64)
+}
+
+// parseVanishedUIDs extracts the UID set out of a VANISHED response's
+// fields, skipping over the "(EARLIER)" marker when the server sends one.
+func parseVanishedUIDs(fields []interface{}) ([]uint32, error) {
+	if len(fields) == 0 {
+		return nil, fmt.Errorf("imap: VANISHED response without a UID set")
+	}
+
+	uidSet, err := imap.ParseString(fields[len(fields)-1])
+	if err != nil {
+		return nil, fmt.Errorf("invalid VANISHED uid set: %w", err)
+	}
+
+	seqSet, err := imap.ParseSeqSet(uidSet)
+	if err != nil {
+		return nil, fmt.Errorf("invalid VANISHED uid set: %w", err)
+	}
+
+	return expandSeqSet(seqSet), nil
+}
+
+// expandSeqSet lists every UID a sequence set contains. VANISHED never uses
+// "*", so open-ended ranges (Stop == 0) don't need to be handled here.
+func expandSeqSet(seqSet *imap.SeqSet) []uint32 {
+	var uids []uint32
+	for _, seq := range seqSet.Set {
+		for uid := seq.Start; seq.Stop != 0 && uid <= seq.Stop; uid++ {
+			uids = append(uids, uid)
+		}
+	}
+	return uids
+}
+
+// SetMessageStore attaches store to c, routing GetInbox/GetFolderMessages/
+// GetEmailByID through the cache instead of hitting IMAP on every call.
+func (c *IMAPClient) SetMessageStore(store *MessageStore) {
+	c.store = store
+}
+
+// Sync performs an incremental sync of folder into the attached
+// MessageStore: on the first sync it records UIDVALIDITY/HIGHESTMODSEQ and
+// pulls every message; on later syncs it only pulls what changed, using
+// CONDSTORE/QRESYNC (RFC 7162) when the server advertises them.
+func (c *IMAPClient) Sync(folder string) error {
+	if c.store == nil {
+		return fmt.Errorf("no message store attached")
+	}
+
+	return c.withConn(func(imapClient *client.Client) error {
+		qresync, err := hasCapability(imapClient, "QRESYNC")
+		if err != nil {
+			return err
+		}
+		condstore, err := hasCapability(imapClient, "CONDSTORE")
+		if err != nil {
+			return err
+		}
+
+		prevState, known, err := c.store.getState(folder)
+		if err != nil {
+			return fmt.Errorf("failed to read sync state: %w", err)
+		}
+
+		var mbox *imap.MailboxStatus
+		var highestModSeq uint64
+		var vanishedUIDs []uint32
+
+		switch {
+		case known && qresync:
+			knownUIDs, listErr := c.cachedUIDSet(folder, prevState.UIDValidity)
+			if listErr != nil {
+				return listErr
+			}
+			mbox, highestModSeq, vanishedUIDs, err = selectQresync(imapClient, folder, prevState.UIDValidity, prevState.HighestModSeq, knownUIDs)
+		case condstore:
+			mbox, highestModSeq, err = selectCondstore(imapClient, folder)
+		default:
+			mbox, err = imapClient.Select(folder, false)
+		}
+		if err != nil {
+			return fmt.Errorf("failed to select folder %s: %w", folder, err)
+		}
+
+		if !known || mbox.UidValidity != prevState.UIDValidity {
+			if known {
+				if err := c.store.invalidateFolder(folder, prevState.UIDValidity); err != nil {
+					return fmt.Errorf("failed to invalidate stale cache: %w", err)
+				}
+			}
+			return c.fullSync(imapClient, folder, mbox, highestModSeq)
+		}
+
+		switch {
+		case len(vanishedUIDs) > 0:
+			if err := c.store.deleteMessages(folder, prevState.UIDValidity, vanishedUIDs); err != nil {
+				return fmt.Errorf("failed to purge expunged messages: %w", err)
+			}
+		case condstore && !qresync:
+			// CONDSTORE alone has no VANISHED response to tell us what was
+			// expunged, so diff the full UID list against the cache instead.
+			if err := c.purgeExpunged(imapClient, folder, prevState); err != nil {
+				return err
+			}
+		}
+
+		if condstore {
+			return c.incrementalSyncCondstore(imapClient, folder, mbox, highestModSeq, prevState)
+		}
+
+		return c.incrementalSyncPoll(imapClient, folder, mbox, prevState)
+	})
+}
+
+// hasCapability checks the server's advertised capabilities for name.
+func hasCapability(imapClient *client.Client, name string) (bool, error) {
+	caps, err := imapClient.Capability()
+	if err != nil {
+		return false, fmt.Errorf("failed to fetch capabilities: %w", err)
+	}
+	return caps[name], nil
+}
+
+// selectCondstore performs a CONDSTORE-enabled SELECT and returns the
+// resulting mailbox status together with the server's HIGHESTMODSEQ, which
+// a plain SELECT has no way to report.
+func selectCondstore(imapClient *client.Client, folder string) (*imap.MailboxStatus, uint64, error) {
+	mbox := &imap.MailboxStatus{Name: folder, Items: make(map[imap.StatusItem]interface{})}
+	res := &modSeqSelectResponse{sel: responses.Select{Mailbox: mbox}}
+
+	if _, err := imapClient.Execute(&condstoreSelectCommand{Folder: folder}, res); err != nil {
+		return nil, 0, fmt.Errorf("failed CONDSTORE select: %w", err)
+	}
+	return mbox, res.HighestModSeq, nil
+}
+
+// selectQresync performs the QRESYNC-enabled SELECT and returns the
+// resulting mailbox status, the server's HIGHESTMODSEQ, and the UIDs it
+// reports as VANISHED (EARLIER) — i.e. expunged since modSeq.
+func selectQresync(imapClient *client.Client, folder string, uidValidity uint32, modSeq uint64, knownUIDs *imap.SeqSet) (*imap.MailboxStatus, uint64, []uint32, error) {
+	cmd := &qresyncSelectCommand{Folder: folder, UIDValidity: uidValidity, ModSeq: modSeq, KnownUIDs: knownUIDs}
+	mbox := &imap.MailboxStatus{Name: folder, Items: make(map[imap.StatusItem]interface{})}
+	res := &modSeqSelectResponse{sel: responses.Select{Mailbox: mbox}}
+
+	if _, err := imapClient.Execute(cmd, res); err != nil {
+		return nil, 0, nil, fmt.Errorf("failed QRESYNC select: %w", err)
+	}
+	return mbox, res.HighestModSeq, res.VanishedUIDs, nil
+}
+
+// cachedUIDSet builds the known-UIDs sequence set QRESYNC needs from what's
+// currently cached for folder.
+func (c *IMAPClient) cachedUIDSet(folder string, uidValidity uint32) (*imap.SeqSet, error) {
+	cached, err := c.store.listMessages(folder, uidValidity)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list cached messages: %w", err)
+	}
+
+	seqSet := new(imap.SeqSet)
+	for _, msg := range cached {
+		seqSet.AddNum(msg.UID)
+	}
+	return seqSet, nil
+}
+
+// purgeExpunged removes cached messages whose UID the server no longer
+// knows about. It's the expunge-detection fallback for servers that support
+// CONDSTORE but not QRESYNC, which never send a VANISHED response.
+func (c *IMAPClient) purgeExpunged(imapClient *client.Client, folder string, prevState folderState) error {
+	cached, err := c.store.listMessages(folder, prevState.UIDValidity)
+	if err != nil {
+		return fmt.Errorf("failed to list cached messages: %w", err)
+	}
+	if len(cached) == 0 {
+		return nil
+	}
+
+	serverUIDs, err := imapClient.UidSearch(imap.NewSearchCriteria())
+	if err != nil {
+		return fmt.Errorf("failed to search current messages: %w", err)
+	}
+
+	onServer := make(map[uint32]bool, len(serverUIDs))
+	for _, uid := range serverUIDs {
+		onServer[uid] = true
+	}
+
+	var expunged []uint32
+	for _, msg := range cached {
+		if !onServer[msg.UID] {
+			expunged = append(expunged, msg.UID)
+		}
+	}
+	if len(expunged) == 0 {
+		return nil
+	}
+
+	return c.store.deleteMessages(folder, prevState.UIDValidity, expunged)
+}
+
+// fullSync pulls every UID in folder and replaces the cached state wholesale.
+// Used for the very first sync of a folder, and whenever UIDVALIDITY changes.
+func (c *IMAPClient) fullSync(imapClient *client.Client, folder string, mbox *imap.MailboxStatus, highestModSeq uint64) error {
+	criteria := imap.NewSearchCriteria()
+	uids, err := imapClient.UidSearch(criteria)
+	if err != nil {
+		return fmt.Errorf("failed to search all messages: %w", err)
+	}
+
+	messages, err := fetchForCache(imapClient, uids)
+	if err != nil {
+		return err
+	}
+
+	if err := c.store.putMessages(folder, mbox.UidValidity, messages); err != nil {
+		return fmt.Errorf("failed to cache messages: %w", err)
+	}
+
+	var lastUID uint32
+	for _, uid := range uids {
+		if uid > lastUID {
+			lastUID = uid
+		}
+	}
+
+	return c.store.saveState(folder, folderState{
+		UIDValidity:   mbox.UidValidity,
+		HighestModSeq: highestModSeq,
+		LastUID:       lastUID,
+	})
+}
+
+// incrementalSyncCondstore fetches only what changed since prevState using
+// CHANGEDSINCE for flag updates and a plain UID search for new messages.
+// Expunges are purged by the caller before this runs (see Sync).
+func (c *IMAPClient) incrementalSyncCondstore(imapClient *client.Client, folder string, mbox *imap.MailboxStatus, highestModSeq uint64, prevState folderState) error {
+	if highestModSeq > prevState.HighestModSeq {
+		if err := c.syncChangedFlags(imapClient, folder, highestModSeq, prevState); err != nil {
+			return err
+		}
+	}
+
+	return c.syncNewMessages(imapClient, folder, mbox, highestModSeq, prevState)
+}
+
+// incrementalSyncPoll is the fallback for servers without CONDSTORE: refetch
+// flags for every cached UID and search for anything newer than LastUID.
+func (c *IMAPClient) incrementalSyncPoll(imapClient *client.Client, folder string, mbox *imap.MailboxStatus, prevState folderState) error {
+	cached, err := c.store.listMessages(folder, prevState.UIDValidity)
+	if err != nil {
+		return fmt.Errorf("failed to list cached messages: %w", err)
+	}
+	if len(cached) == 0 {
+		return c.syncNewMessages(imapClient, folder, mbox, 0, prevState)
+	}
+
+	seqSet := new(imap.SeqSet)
+	for _, msg := range cached {
+		seqSet.AddNum(msg.UID)
+	}
+
+	flagsByUID, err := fetchFlags(imapClient, seqSet)
+	if err != nil {
+		return err
+	}
+
+	if err := c.store.updateFlags(folder, prevState.UIDValidity, flagsByUID); err != nil {
+		return fmt.Errorf("failed to update cached flags: %w", err)
+	}
+
+	return c.syncNewMessages(imapClient, folder, mbox, 0, prevState)
+}
+
+// syncChangedFlags applies flag changes reported since prevState.HighestModSeq.
+func (c *IMAPClient) syncChangedFlags(imapClient *client.Client, folder string, highestModSeq uint64, prevState folderState) error {
+	allUIDs := new(imap.SeqSet)
+	allUIDs.AddRange(1, 0) // 1:* — every message currently in the mailbox
+
+	cmd := &condstoreFetchCommand{
+		SeqSet:       allUIDs,
+		Items:        []imap.FetchItem{imap.FetchUid, imap.FetchFlags, "MODSEQ"},
+		ChangedSince: prevState.HighestModSeq,
+	}
+
+	messages := make(chan *imap.Message, 32)
+	res := &responses.Fetch{Messages: messages, SeqSet: allUIDs, Uid: true}
+
+	done := make(chan error, 1)
+	go func() {
+		_, err := imapClient.Execute(cmd, res)
+		done <- err
+		close(messages)
+	}()
+
+	flagsByUID := make(map[uint32][]string)
+	for msg := range messages {
+		flagsByUID[msg.Uid] = msg.Flags
+	}
+	if err := <-done; err != nil {
+		return fmt.Errorf("failed CHANGEDSINCE fetch: %w", err)
+	}
+
+	if err := c.store.updateFlags(folder, prevState.UIDValidity, flagsByUID); err != nil {
+		return fmt.Errorf("failed to update cached flags: %w", err)
+	}
+
+	return c.store.saveState(folder, folderState{
+		UIDValidity:   prevState.UIDValidity,
+		HighestModSeq: highestModSeq,
+		LastUID:       prevState.LastUID,
+	})
+}
+
+// syncNewMessages searches for UIDs after prevState.LastUID, caches them,
+// and advances LastUID/HighestModSeq in the stored state.
+func (c *IMAPClient) syncNewMessages(imapClient *client.Client, folder string, mbox *imap.MailboxStatus, highestModSeq uint64, prevState folderState) error {
+	criteria := imap.NewSearchCriteria()
+	criteria.Uid = new(imap.SeqSet)
+	criteria.Uid.AddRange(prevState.LastUID+1, 0)
+
+	uids, err := imapClient.UidSearch(criteria)
+	if err != nil {
+		return fmt.Errorf("failed to search new messages: %w", err)
+	}
+
+	lastUID := prevState.LastUID
+	if len(uids) > 0 {
+		messages, err := fetchForCache(imapClient, uids)
+		if err != nil {
+			return err
+		}
+		if err := c.store.putMessages(folder, mbox.UidValidity, messages); err != nil {
+			return fmt.Errorf("failed to cache new messages: %w", err)
+		}
+		for _, uid := range uids {
+			if uid > lastUID {
+				lastUID = uid
+			}
+		}
+	}
+
+	return c.store.saveState(folder, folderState{
+		UIDValidity:   mbox.UidValidity,
+		HighestModSeq: highestModSeq,
+		LastUID:       lastUID,
+	})
+}
+
+// fetchForCache fetches envelope, flags and size for uids and converts them
+// to the store's on-disk representation.
+func fetchForCache(imapClient *client.Client, uids []uint32) ([]cachedMessage, error) {
+	if len(uids) == 0 {
+		return nil, nil
+	}
+
+	seqSet := new(imap.SeqSet)
+	seqSet.AddNum(uids...)
+
+	items := []imap.FetchItem{imap.FetchUid, imap.FetchEnvelope, imap.FetchFlags, imap.FetchRFC822Size}
+	messages := make(chan *imap.Message, 32)
+	done := make(chan error, 1)
+
+	go func() {
+		done <- imapClient.UidFetch(seqSet, items, messages)
+	}()
+
+	var cached []cachedMessage
+	for msg := range messages {
+		entry := cachedMessage{
+			UID:     msg.Uid,
+			Subject: msg.Envelope.Subject,
+			Date:    msg.Envelope.Date,
+			Flags:   msg.Flags,
+			Size:    msg.Size,
+		}
+		if len(msg.Envelope.From) > 0 {
+			entry.From = msg.Envelope.From[0].Address()
+		}
+		for _, addr := range msg.Envelope.To {
+			entry.To = append(entry.To, addr.Address())
+		}
+		cached = append(cached, entry)
+	}
+
+	if err := <-done; err != nil {
+		return nil, fmt.Errorf("failed to fetch messages for cache: %w", err)
+	}
+
+	return cached, nil
+}
+
+// fetchFlags fetches just the flags for seqSet, keyed by UID.
+func fetchFlags(imapClient *client.Client, seqSet *imap.SeqSet) (map[uint32][]string, error) {
+	items := []imap.FetchItem{imap.FetchUid, imap.FetchFlags}
+	messages := make(chan *imap.Message, 32)
+	done := make(chan error, 1)
+
+	go func() {
+		done <- imapClient.UidFetch(seqSet, items, messages)
+	}()
+
+	flagsByUID := make(map[uint32][]string)
+	for msg := range messages {
+		flagsByUID[msg.Uid] = msg.Flags
+	}
+
+	if err := <-done; err != nil {
+		return nil, fmt.Errorf("failed to fetch flags: %w", err)
+	}
+
+	return flagsByUID, nil
+}
+
+// cachedFolderMessages serves a page of folder from the local cache. ok is
+// false when the folder has never been synced, so callers should fall back
+// to fetching from IMAP directly.
+func (c *IMAPClient) cachedFolderMessages(folder string, page, pageSize int) (*GetFolderResult, bool, error) {
+	state, known, err := c.store.getState(folder)
+	if err != nil || !known {
+		return nil, false, err
+	}
+
+	cached, err := c.store.listMessages(folder, state.UIDValidity)
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to read cached messages: %w", err)
+	}
+
+	// Cached messages come back in UID order; reverse to newest-first to
+	// match the ordering GetInbox/GetFolderMessages have always returned.
+	for i, j := 0, len(cached)-1; i < j; i, j = i+1, j-1 {
+		cached[i], cached[j] = cached[j], cached[i]
+	}
+
+	totalCount := uint32(len(cached))
+	offset := (page - 1) * pageSize
+	if offset >= len(cached) {
+		return &GetFolderResult{Messages: []Message{}, TotalCount: totalCount}, true, nil
+	}
+
+	end := offset + pageSize
+	if end > len(cached) {
+		end = len(cached)
+	}
+
+	messages := make([]Message, 0, end-offset)
+	for _, msg := range cached[offset:end] {
+		messages = append(messages, msg.toMessage())
+	}
+
+	return &GetFolderResult{Messages: messages, TotalCount: totalCount}, true, nil
+}
+
+// cachedMessageByUID looks up a single cached message by UID string.
+func (c *IMAPClient) cachedMessageByUID(folder, id string) (*Message, bool, error) {
+	state, known, err := c.store.getState(folder)
+	if err != nil || !known {
+		return nil, false, err
+	}
+
+	uid, err := strconv.ParseUint(id, 10, 32)
+	if err != nil {
+		return nil, false, fmt.Errorf("invalid email ID: %w", err)
+	}
+
+	cached, ok, err := c.store.getMessage(folder, state.UIDValidity, uint32(uid))
+	if err != nil || !ok {
+		return nil, false, err
+	}
+
+	message := cached.toMessage()
+	return &message, true, nil
+}
+
+// toMessage converts a cached message to the API-facing Message type.
+func (cm cachedMessage) toMessage() Message {
+	return Message{
+		ID:      fmt.Sprintf("%d", cm.UID),
+		Subject: cm.Subject,
+		From:    cm.From,
+		To:      cm.To,
+		Date:    cm.Date,
+		Flags:   cm.Flags,
+		Size:    cm.Size,
+		Body:    cm.Body,
+	}
+}
+
+// StartBackgroundSync runs Sync for folder on an interval until stop is
+// closed. Intended to be launched once per connected account right after
+// Connect, so paginated reads are served from a warm cache.
+func (c *IMAPClient) StartBackgroundSync(folder string, interval time.Duration, stop <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			if err := c.Sync(folder); err != nil {
+				fmt.Println("background sync failed for", folder, ":", err)
+			}
+		case <-stop:
+			return
+		}
+	}
+}
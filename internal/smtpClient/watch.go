@@ -0,0 +1,215 @@
+package smtpclient
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	idle "github.com/emersion/go-imap-idle"
+	"github.com/emersion/go-imap/client"
+)
+
+// reIdleInterval is how long we let a single IDLE command run before
+// stopping it and issuing a new one. RFC 2177 recommends re-issuing IDLE
+// before the 30 minute server timeout, so we use a safety margin under it.
+const reIdleInterval = 29 * time.Minute
+
+// MailboxEvent is a typed unilateral update delivered by Watch.
+type MailboxEvent interface {
+	isMailboxEvent()
+}
+
+// NewMessage is emitted when the server reports additional messages in the
+// watched folder (an untagged EXISTS with a higher count than before).
+type NewMessage struct {
+	SeqNum uint32
+}
+
+func (NewMessage) isMailboxEvent() {}
+
+// Expunged is emitted when a message is removed from the watched folder.
+type Expunged struct {
+	SeqNum uint32
+}
+
+func (Expunged) isMailboxEvent() {}
+
+// FlagsChanged is emitted when a message's flags are updated.
+type FlagsChanged struct {
+	SeqNum uint32
+	Flags  []string
+}
+
+func (FlagsChanged) isMailboxEvent() {}
+
+// watchSubscriber is one Watch() caller's event channel for a given folder.
+type watchSubscriber struct {
+	events chan MailboxEvent
+}
+
+// Watch subscribes to unilateral updates for folder using IMAP IDLE and
+// returns a channel of typed mailbox events. Updates are delivered on a
+// connection dedicated to IDLE, separate from the pool used to serve
+// requests, since a connection running IDLE cannot do anything else.
+// The returned channel is closed once ctx is canceled.
+func (c *IMAPClient) Watch(ctx context.Context, folder string) (<-chan MailboxEvent, error) {
+	c.watchMu.Lock()
+	if c.watchers == nil {
+		c.watchers = make(map[string][]*watchSubscriber)
+	}
+	sub := &watchSubscriber{events: make(chan MailboxEvent, 32)}
+	_, alreadyWatching := c.watchers[folder]
+	c.watchers[folder] = append(c.watchers[folder], sub)
+	c.watchMu.Unlock()
+
+	if !alreadyWatching {
+		if err := c.startIdleLoop(folder); err != nil {
+			c.removeWatcher(folder, sub)
+			return nil, err
+		}
+	}
+
+	go func() {
+		<-ctx.Done()
+		c.removeWatcher(folder, sub)
+	}()
+
+	return sub.events, nil
+}
+
+func (c *IMAPClient) removeWatcher(folder string, sub *watchSubscriber) {
+	c.watchMu.Lock()
+	defer c.watchMu.Unlock()
+
+	subs := c.watchers[folder]
+	for i, s := range subs {
+		if s == sub {
+			c.watchers[folder] = append(subs[:i], subs[i+1:]...)
+			break
+		}
+	}
+	close(sub.events)
+
+	if len(c.watchers[folder]) == 0 {
+		delete(c.watchers, folder)
+	}
+}
+
+func (c *IMAPClient) publishEvent(folder string, event MailboxEvent) {
+	c.watchMu.Lock()
+	defer c.watchMu.Unlock()
+
+	for _, sub := range c.watchers[folder] {
+		select {
+		case sub.events <- event:
+		default:
+			// Subscriber is too slow to keep up; drop the event rather
+			// than block the IDLE loop for every other watcher.
+		}
+	}
+}
+
+// startIdleLoop dials a dedicated connection for folder (if one isn't
+// already running) and idles on it until no more subscribers are left.
+func (c *IMAPClient) startIdleLoop(folder string) error {
+	watchClient, err := c.dialWatchConn()
+	if err != nil {
+		return fmt.Errorf("failed to open watch connection: %w", err)
+	}
+
+	updates := make(chan client.Update, 32)
+	watchClient.Updates = updates
+
+	if _, err := watchClient.Select(folder, false); err != nil {
+		watchClient.Logout()
+		return fmt.Errorf("failed to select folder %s for watch: %w", folder, err)
+	}
+
+	idleClient := idle.NewClient(watchClient)
+
+	go func() {
+		for update := range updates {
+			c.translateUpdate(folder, update)
+		}
+	}()
+
+	go c.runIdle(folder, watchClient, idleClient)
+
+	return nil
+}
+
+// runIdle re-issues IDLE every reIdleInterval and reconnects on drop, for as
+// long as folder still has subscribers.
+func (c *IMAPClient) runIdle(folder string, watchClient *client.Client, idleClient *idle.Client) {
+	for {
+		c.watchMu.Lock()
+		stillWatching := len(c.watchers[folder]) > 0
+		c.watchMu.Unlock()
+		if !stillWatching {
+			watchClient.Logout()
+			return
+		}
+
+		stop := make(chan struct{})
+		done := make(chan error, 1)
+		go func() {
+			done <- idleClient.IdleWithFallback(stop, reIdleInterval)
+		}()
+
+		timer := time.NewTimer(reIdleInterval)
+		select {
+		case <-timer.C:
+			close(stop)
+			<-done
+		case err := <-done:
+			timer.Stop()
+			if err != nil {
+				newClient, dialErr := c.dialWatchConn()
+				if dialErr != nil {
+					// Back off briefly before the next attempt so a
+					// persistently unreachable server doesn't spin.
+					time.Sleep(5 * time.Second)
+					continue
+				}
+				updates := make(chan client.Update, 32)
+				newClient.Updates = updates
+				if _, err := newClient.Select(folder, false); err != nil {
+					newClient.Logout()
+					time.Sleep(5 * time.Second)
+					continue
+				}
+				go func() {
+					for update := range updates {
+						c.translateUpdate(folder, update)
+					}
+				}()
+				watchClient = newClient
+				idleClient = idle.NewClient(watchClient)
+			}
+		}
+	}
+}
+
+// dialWatchConn opens and authenticates a connection to be used exclusively
+// for IDLE, independent of the request-serving connection pool.
+func (c *IMAPClient) dialWatchConn() (*client.Client, error) {
+	return dialAndLogin(c.config)
+}
+
+// translateUpdate converts a raw unilateral client.Update into a typed
+// MailboxEvent and publishes it to every subscriber of folder.
+func (c *IMAPClient) translateUpdate(folder string, update client.Update) {
+	switch u := update.(type) {
+	case *client.MailboxUpdate:
+		c.publishEvent(folder, NewMessage{SeqNum: u.Mailbox.Messages})
+	case *client.ExpungeUpdate:
+		c.publishEvent(folder, Expunged{SeqNum: u.SeqNum})
+	case *client.MessageUpdate:
+		if u.Message == nil {
+			return
+		}
+		flags := make([]string, len(u.Message.Flags))
+		copy(flags, u.Message.Flags)
+		c.publishEvent(folder, FlagsChanged{SeqNum: u.Message.SeqNum, Flags: flags})
+	}
+}